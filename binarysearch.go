@@ -6,7 +6,10 @@ package main
 
 
 import "fmt"
+import "math"
+import "math/rand"
 import "os"
+import "slices"
 
 
 type Ordered interface {
@@ -16,6 +19,23 @@ type Ordered interface {
 }
 
 
+type Integer interface {
+    ~int | ~int8 | ~int16 | ~int32 | ~int64 |
+    ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 |
+    ~uintptr
+}
+
+
+type Float interface {
+    ~float32 | ~float64
+}
+
+
+type Number interface {
+    Integer | Float
+}
+
+
 func Compare[A Ordered](x, y A) int {
     booltoint := func (b bool) int {
         if b {
@@ -29,16 +49,16 @@ func Compare[A Ordered](x, y A) int {
 }
 
 
-func binarysearch[A Ordered](compare func (x, y A) int, l []A, v A,
-                             i int, j int) int {
+func binarysearch[A any](compare func (x, y A) int, l []A, v A,
+                         i int, j int) int {
     if i == j {
         return -1
     } else {
-        k := (i + j) / 2
-        switch (compare(v, l[k])) {
-            case -1:
+        k := i + (j - i) / 2
+        switch c := compare(v, l[k]); {
+            case c < 0:
                 return binarysearch(compare, l, v, i, k)
-            case 0:
+            case c == 0:
                 return k
             default:
                 return binarysearch(compare, l, v, k + 1, j)
@@ -47,11 +67,249 @@ func binarysearch[A Ordered](compare func (x, y A) int, l []A, v A,
 }
 
 
-func BinarySearch[A Ordered](compare func (x, y A) int, l []A, v A) int {
+func BinarySearch[A any](compare func (x, y A) int, l []A, v A) int {
     return binarysearch(compare, l, v, 0, len(l))
 }
 
 
+// The index of the first element for which pred is false, or len(l) if
+// there's none, given that l is partitioned: pred is true for some prefix
+// of l and false for the rest.
+func PartitionPoint[A any](l []A, pred func(a A) bool) int {
+    i, j := 0, len(l)
+    for i < j {
+        k := i + (j - i) / 2
+        if pred(l[k]) {
+            i = k + 1
+        } else {
+            j = k
+        }
+    }
+    return i
+}
+
+
+// The index of the first element not less than v, which is where v would
+// be inserted before any equal elements.
+func LowerBound[A any](compare func (x, y A) int, l []A, v A) int {
+    return PartitionPoint(l, func(a A) bool {
+                                 return compare(a, v) < 0
+                             })
+}
+
+
+// The index of the first element greater than v, which is where v would be
+// inserted after any equal elements.
+func UpperBound[A any](compare func (x, y A) int, l []A, v A) int {
+    return PartitionPoint(l, func(a A) bool {
+                                 return compare(a, v) <= 0
+                             })
+}
+
+
+// The range l[i:j] of elements equal to v, empty at v's insertion point if
+// there are none.
+func EqualRange[A any](compare func (x, y A) int, l []A, v A) (int, int) {
+    i := LowerBound(compare, l, v)
+    return i, i + UpperBound(compare, l[i:], v)
+}
+
+
+// The index of the first element equal to v and true, or where v would be
+// inserted and false.
+func SearchInsert[A any](compare func (x, y A) int, l []A, v A) (int, bool) {
+    i := LowerBound(compare, l, v)
+    return i, i < len(l) && compare(l[i], v) == 0
+}
+
+
+// Searches sorted data of unknown length, where at(i) returns the element
+// at i, or false past the end.  Probes 1, 2, 4 ... until it passes v or the
+// end, then searches between the last two probes, so it takes O(log i)
+// calls of at to find index i however long the data is.  Returns the same
+// as SearchInsert.
+func ExponentialSearch[A any](compare func (x, y A) int,
+                              at func(i int) (A, bool),
+                              v A) (int, bool) {
+    less := func(i int) bool {
+        a, ok := at(i)
+        return ok && compare(a, v) < 0
+    }
+    if !less(0) {
+        a, ok := at(0)
+        return 0, ok && compare(a, v) == 0
+    }
+    i, j := 0, 1
+    for less(j) {
+        i, j = j, j * 2
+    }
+    // less(i) and !less(j): find the first k in (i, j] with !less(k).
+    i++
+    for i < j {
+        k := i + (j - i) / 2
+        if less(k) {
+            i = k + 1
+        } else {
+            j = k
+        }
+    }
+    a, ok := at(i)
+    return i, ok && compare(a, v) == 0
+}
+
+
+// Like BinarySearch, but guesses where v is from its value relative to the
+// ends of the range, which finds it in O(log log n) probes if the values
+// are uniformly distributed, though O(n) in the worst case.  Returns the
+// index of an element equal to v, or -1.
+func InterpolationSearch[A Number](l []A, v A) int {
+    i, j := 0, len(l) - 1
+    for i <= j && l[i] <= v && v <= l[j] {
+        if l[i] == l[j] {
+            return i
+        }
+        // With infinite ends the guess is NaN or infinite, so bisect.
+        k := i + (j - i) / 2
+        f := (float64(v) - float64(l[i])) / (float64(l[j]) - float64(l[i]))
+        if !math.IsNaN(f) && !math.IsInf(f, 0) {
+            k = min(max(i + int(f * float64(j - i)), i), j)
+        }
+        switch {
+            case l[k] < v:
+                i = k + 1
+            case l[k] > v:
+                j = k - 1
+            default:
+                return k
+        }
+    }
+    return -1
+}
+
+
+// Whether A is an integer type, for the searches over numeric domains,
+// which stop when they can't narrow the range any further.
+func integral[A Number]() bool {
+    return A(1) / 2 == 0
+}
+
+
+// The least x in [lo, hi] for which pred is true, given that pred is false
+// up to some point and true after it, or hi if it's never true.  Over the
+// integers the result is exact, and over the floats it's within one ulp,
+// both taking O(log (hi - lo)) calls of pred.  hi - lo must not overflow.
+func SearchFunc[A Number](lo, hi A, pred func(x A) bool) A {
+    if pred(lo) {
+        return lo
+    }
+    // !pred(lo), and pred(hi) or hi is past the last x to try.
+    for {
+        mid := lo + (hi - lo) / 2
+        if mid == lo || mid == hi {
+            return hi
+        }
+        if pred(mid) {
+            hi = mid
+        } else {
+            lo = mid
+        }
+    }
+}
+
+
+// The x in [lo, hi] at which f is least, given that f is unimodal there:
+// decreasing up to its minimum and increasing after it.  Each step compares
+// f at the thirds and discards the third that can't hold the minimum, until
+// there are three integers left to try, or the floats at the thirds are the
+// ends.  For a maximum, negate f.
+func TernarySearch[A Number](lo, hi A, f func(x A) float64) A {
+    integer := integral[A]()
+    for !integer || hi - lo > 2 {
+        m1 := lo + (hi - lo) / 3
+        m2 := hi - (hi - lo) / 3
+        if m1 == lo || m2 == hi {
+            break
+        }
+        if f(m1) < f(m2) {
+            hi = m2
+        } else {
+            lo = m1
+        }
+    }
+    if !integer {
+        return lo + (hi - lo) / 2
+    }
+    best := lo
+    for x := lo + 1; x <= hi && x > lo; x++ {
+        if f(x) < f(best) {
+            best = x
+        }
+    }
+    return best
+}
+
+
+// Runs test on n random cases, reporting the first that fails: test
+// returns a description of the failure, or "" if it passes.
+func property(name string, n int, test func(r *rand.Rand) string) bool {
+    r := rand.New(rand.NewSource(1))
+    for i := 0; i < n; i++ {
+        if failure := test(r); failure != "" {
+            fmt.Printf("%v failed: %v\n", name, failure)
+            return false
+        }
+    }
+    return true
+}
+
+
+// A sorted slice of up to 100 ints, with duplicates.
+func randomsorted(r *rand.Rand) []int {
+    l := make([]int, r.Intn(101))
+    for i := range l {
+        l[i] = r.Intn(len(l) + 1) * 2
+    }
+    slices.Sort(l)
+    return l
+}
+
+
+// Compares the searches with linear scans over sorted slices of every
+// length up to 100, with runs of duplicates, for values in and around them.
+func checkbounds() bool {
+    for n := 0; n <= 100; n++ {
+        l := make([]int, n)
+        for i := range l {
+            l[i] = rand.Intn(n / 2 + 1) * 2
+        }
+        slices.Sort(l)
+        for v := -1; v <= n + 1; v++ {
+            lower, upper := 0, 0
+            for _, e := range l {
+                if e < v {
+                    lower++
+                }
+                if e <= v {
+                    upper++
+                }
+            }
+            i, j := EqualRange(Compare[int], l, v)
+            k, found := SearchInsert(Compare[int], l, v)
+            b := BinarySearch(Compare[int], l, v)
+            if LowerBound(Compare[int], l, v) != lower ||
+               UpperBound(Compare[int], l, v) != upper ||
+               i != lower || j != upper ||
+               k != lower || found != (lower < upper) ||
+               (b < 0) != (lower == upper) || b >= 0 && l[b] != v {
+                fmt.Printf("failed for %v in %v\n", v, l)
+                return false
+            }
+        }
+    }
+    return true
+}
+
+
 func main() {
     fmt.Printf("%v\n",
                BinarySearch(Compare[int],
@@ -60,5 +318,159 @@ func main() {
     // => 6
 
 
+    type Element struct {
+        symbol string
+        number int
+    }
+    fmt.Printf("%v\n",
+               BinarySearch(func(x, y Element) int {
+                                return Compare(x.number, y.number)
+                            },
+                            []Element{ { "H", 1 }, { "He", 2 }, { "Li", 3 },
+                                       { "Be", 4 }, { "B", 5 }, { "C", 6 } },
+                            Element{ "", 5 }))
+    // => 4
+
+
+    l := []int{ 10, 20, 20, 20, 30, 40 }
+    fmt.Printf("%v %v\n",
+               LowerBound(Compare[int], l, 20),
+               UpperBound(Compare[int], l, 20))
+    // => 1 4
+    i, j := EqualRange(Compare[int], l, 20)
+    fmt.Printf("%v %v %v\n", i, j, l[i:j])
+    // => 1 4 [20 20 20]
+    i, j = EqualRange(Compare[int], l, 25)
+    fmt.Printf("%v %v %v\n", i, j, l[i:j])
+    // => 4 4 []
+
+    k, found := SearchInsert(Compare[int], l, 35)
+    fmt.Printf("%v %v\n", k, found)
+    // => 5 false
+    k, found = SearchInsert(Compare[int], []int{}, 35)
+    fmt.Printf("%v %v\n", k, found)
+    // => 0 false
+
+    fmt.Printf("%v\n", PartitionPoint([]int{ 2, 4, 6, 7, 9, 10 },
+                                      func(a int) bool {
+                                          return a % 2 == 0
+                                      }))
+    // => 3
+
+
+    fmt.Printf("%v\n", checkbounds())
+    // => true
+
+
+    squares := func(i int) (int, bool) {
+        return i * i, i <= 1000
+    }
+    k, found = ExponentialSearch(Compare[int], squares, 625)
+    fmt.Printf("%v %v\n", k, found)
+    // => 25 true
+
+    fmt.Printf("%v %v %v\n",
+               InterpolationSearch([]int{ 10, 20, 30, 40, 50, 60, 70, 80, 90, 100 },
+                                   70),
+               InterpolationSearch([]float64{ math.Inf(-1), 0, math.Inf(1) }, 0),
+               InterpolationSearch([]float64{ 0, math.Inf(1) }, math.Inf(1)))
+    // => 6 1 1
+
+    fmt.Printf("%v %.6f\n",
+               SearchFunc(0, 100, func(x int) bool { return x * x >= 50 }),
+               SearchFunc(0.0, 100, func(x float64) bool { return x * x >= 50 }))
+    // => 8 7.071068
+
+    fmt.Printf("%v %.6f\n",
+               TernarySearch(-100, 100, func(x int) float64 {
+                                            return float64((x - 7) * (x - 7))
+                                        }),
+               TernarySearch(0.0, math.Pi, func(x float64) float64 {
+                                              return -math.Sin(x)
+                                          }))
+    // => 7 1.570796
+
+
+    fmt.Printf("%v %v %v %v %v %v %v\n",
+        property("ExponentialSearch", 1000, func(r *rand.Rand) string {
+            l := randomsorted(r)
+            v := r.Intn(len(l) * 2 + 3) - 1
+            at := func(i int) (int, bool) {
+                if i < len(l) {
+                    return l[i], true
+                }
+                return 0, false
+            }
+            i, found := ExponentialSearch(Compare[int], at, v)
+            j, found1 := SearchInsert(Compare[int], l, v)
+            if i != j || found != found1 {
+                return fmt.Sprintf("%v in %v", v, l)
+            }
+            return ""
+        }),
+        property("InterpolationSearch", 1000, func(r *rand.Rand) string {
+            l := randomsorted(r)
+            v := r.Intn(len(l) * 2 + 3) - 1
+            i := InterpolationSearch(l, v)
+            if i < 0 && slices.Contains(l, v) || i >= 0 && l[i] != v {
+                return fmt.Sprintf("%v in %v", v, l)
+            }
+            return ""
+        }),
+        property("InterpolationSearch float64", 1000, func(r *rand.Rand) string {
+            l := []float64{ math.Inf(-1), math.Inf(1) }
+            for n := r.Intn(20); n > 0; n-- {
+                l = append(l, math.Round(r.NormFloat64() * 10))
+            }
+            slices.Sort(l)
+            v := l[r.Intn(len(l))]
+            if r.Intn(2) == 0 {
+                v += 0.5
+            }
+            i := InterpolationSearch(l, v)
+            if i < 0 && slices.Contains(l, v) || i >= 0 && l[i] != v {
+                return fmt.Sprintf("%v in %v", v, l)
+            }
+            return ""
+        }),
+        property("SearchFunc int", 1000, func(r *rand.Rand) string {
+            lo := r.Intn(1000) - 500
+            hi := lo + r.Intn(1000)
+            t := lo + r.Intn(hi - lo + 2)
+            if x := SearchFunc(lo, hi, func(x int) bool { return x >= t }); x != min(t, hi) {
+                return fmt.Sprintf("%v in [%v, %v] gave %v", t, lo, hi, x)
+            }
+            return ""
+        }),
+        property("SearchFunc float64", 1000, func(r *rand.Rand) string {
+            c := r.Float64() * 1e6
+            pred := func(x float64) bool { return x * x >= c }
+            x := SearchFunc(0, 1e3, pred)
+            if !pred(x) || x > 0 && pred(math.Nextafter(x, 0)) {
+                return fmt.Sprintf("sqrt %v gave %v", c, x)
+            }
+            return ""
+        }),
+        property("TernarySearch int", 1000, func(r *rand.Rand) string {
+            lo := r.Intn(1000) - 500
+            hi := lo + r.Intn(1000)
+            t := lo + r.Intn(hi - lo + 1)
+            f := func(x int) float64 { return math.Abs(float64(x - t)) }
+            if x := TernarySearch(lo, hi, f); x != t {
+                return fmt.Sprintf("%v in [%v, %v] gave %v", t, lo, hi, x)
+            }
+            return ""
+        }),
+        property("TernarySearch float64", 1000, func(r *rand.Rand) string {
+            t := r.Float64() * 200 - 100
+            f := func(x float64) float64 { return (x - t) * (x - t) }
+            if x := TernarySearch(-100.0, 100, f); math.Abs(x - t) > 1e-6 {
+                return fmt.Sprintf("%v gave %v", t, x)
+            }
+            return ""
+        }))
+    // => true true true true true true true
+
+
     os.Exit(0)
 }
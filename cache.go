@@ -0,0 +1,932 @@
+package main
+
+
+// host$ go build cache.go
+// host$ ./cache
+
+
+import "container/heap"
+import "fmt"
+import "math"
+import "os"
+import "time"
+
+
+type HashTableEntry[K any, V comparable] struct {
+    k K
+    v V
+}
+
+
+// The table grows by growth when the load factor rises above grow, and
+// shrinks by the same factor when it falls below shrink, but never below
+// minslots.  shrink * growth must be less than grow so that a table that has
+// just shrunk can't immediately grow again, and vice versa.
+type HashTablePolicy struct {
+    grow float32
+    shrink float32
+    growth float32
+    minslots int
+    expected int
+    incremental int
+}
+
+
+type HashTableOption func(p *HashTablePolicy)
+
+
+func HashTableWithThresholds(grow, shrink float32) HashTableOption {
+    return func(p *HashTablePolicy) {
+        p.grow = grow
+        p.shrink = shrink
+    }
+}
+
+
+func HashTableWithGrowthFactor(growth float32) HashTableOption {
+    return func(p *HashTablePolicy) {
+        p.growth = growth
+    }
+}
+
+
+func HashTableWithMinSlots(minslots int) HashTableOption {
+    return func(p *HashTablePolicy) {
+        p.minslots = minslots
+    }
+}
+
+
+// Instead of rehashing every entry at once, a resize keeps the old slots
+// alongside the new ones and each Set or Remove moves the key's own old
+// bucket plus up to nbuckets more, bounding the cost of any one call.
+// nbuckets needs to be at least 1 / ((growth - 1) * grow), 2 by default, for
+// each migration to finish before the next resize is due.
+func HashTableWithIncrementalResize(nbuckets int) HashTableOption {
+    return func(p *HashTablePolicy) {
+        p.incremental = nbuckets
+    }
+}
+
+
+// Pre-sizes the table so that n entries can be set without a resize.
+func HashTableWithExpected(n int) HashTableOption {
+    return func(p *HashTablePolicy) {
+        p.expected = n
+    }
+}
+
+
+type HashTable[K any, V comparable] struct {
+    nentries int
+    nslots int
+    slots [][]HashTableEntry[K, V]
+    hash func(k K, m int) int
+    equal func(x, y K) bool
+    policy HashTablePolicy
+    oldslots [][]HashTableEntry[K, V]
+    migrated int
+}
+
+
+func hashtablenew[K any, V comparable](nslots int,
+                                       hash func(k K, m int) int,
+                                       equal func(x, y K) bool,
+                                       policy HashTablePolicy) *HashTable[K, V] {
+    h := new(HashTable[K, V])
+    h.nentries = 0
+    h.nslots = nslots
+    h.slots = make([][]HashTableEntry[K, V], nslots)
+    for i := range h.slots {
+        h.slots[i] = make([]HashTableEntry[K, V], 0)
+    }
+    h.hash = hash
+    h.equal = equal
+    h.policy = policy
+    return h
+}
+
+
+func HashTableNew[K, V comparable](nslots int,
+                                   hash func(k K, m int) int,
+                                   options ...HashTableOption) *HashTable[K, V] {
+    return HashTableNewFunc[K, V](nslots,
+                                  hash,
+                                  func(x, y K) bool { return x == y },
+                                  options...)
+}
+
+
+// For keys that aren't comparable, or that need comparing other than with
+// ==, such as case-insensitive strings.  Keys that are equal must hash to
+// the same slot.
+func HashTableNewFunc[K any, V comparable](nslots int,
+                                           hash func(k K, m int) int,
+                                           equal func(x, y K) bool,
+                                           options ...HashTableOption) *HashTable[K, V] {
+    policy := HashTablePolicy{ 0.7, 0.3, 2, nslots, 0, 0 }
+    for _, option := range options {
+        option(&policy)
+    }
+    if policy.minslots < 1 ||
+       policy.growth <= 1 ||
+       policy.grow <= 0 ||
+       policy.shrink < 0 ||
+       policy.incremental < 0 ||
+       policy.shrink * policy.growth >= policy.grow {
+        panic(fmt.Sprintf("HashTableNew: invalid policy %+v", policy))
+    }
+    nslots = max(nslots, policy.minslots, policy.slotsfor(policy.expected))
+    return hashtablenew[K, V](nslots, hash, equal, policy)
+}
+
+
+type Hasher[K any] interface {
+    Hash(k K, m int) int
+    Equal(x, y K) bool
+}
+
+
+func HashTableNewHasher[K any, V comparable](nslots int,
+                                             hasher Hasher[K],
+                                             options ...HashTableOption) *HashTable[K, V] {
+    return HashTableNewFunc[K, V](nslots, hasher.Hash, hasher.Equal, options...)
+}
+
+
+// The number of slots needed to hold n entries without growing.
+func (p HashTablePolicy) slotsfor(n int) int {
+    return max(p.minslots, int(math.Ceil(float64(float32(n) / p.grow))))
+}
+
+
+func HashTableGet[K any, V comparable](h *HashTable[K, V], k K) (V, bool) {
+    if h.oldslots != nil {
+        for _, e := range h.oldslots[h.hash(k, len(h.oldslots))] {
+            if h.equal(e.k, k) {
+                return e.v, true
+            }
+        }
+    }
+    slot := h.hash(k, h.nslots)
+    for _, e := range h.slots[slot] {
+        if h.equal(e.k, k) {
+            return e.v, true
+        }
+    }
+    return *new(V), false
+}
+
+
+func HashTableKeys[K any, V comparable](h *HashTable[K, V]) []K {
+    keys := make([]K, 0, h.nentries)
+    slots := hashtableslots(h)
+    for i, _ := range slots {
+        for _, e := range slots[i] {
+            keys = append(keys, e.k)
+        }
+    }
+    return keys
+}
+
+
+// All buckets, including those still waiting to be migrated.
+func hashtableslots[K any, V comparable](h *HashTable[K, V]) [][]HashTableEntry[K, V] {
+    if h.oldslots == nil {
+        return h.slots
+    }
+    return append(h.oldslots[:len(h.oldslots):len(h.oldslots)], h.slots...)
+}
+
+
+func HashTableLoadFactor[K any, V comparable](h *HashTable[K, V]) float32 {
+    return float32(h.nentries) / float32(h.nslots)
+}
+
+
+func remove[T any](v []T, i int) []T {
+    v[i] = v[len(v) - 1]
+    v = v[:len(v) - 1]
+    return v
+}
+
+
+func hashtablemigratebucket[K any, V comparable](h *HashTable[K, V], slot int) {
+    for _, e := range h.oldslots[slot] {
+        slot2 := h.hash(e.k, h.nslots)
+        h.slots[slot2] = append(h.slots[slot2], e)
+    }
+    h.oldslots[slot] = nil
+}
+
+
+// Moves k's old bucket, so that it only needs looking for in the new slots,
+// then moves up to policy.incremental more.
+func hashtablemigrate[K any, V comparable](h *HashTable[K, V], k K) {
+    if h.oldslots == nil {
+        return
+    }
+    hashtablemigratebucket(h, h.hash(k, len(h.oldslots)))
+    for n := 0;
+        n < h.policy.incremental && h.migrated < len(h.oldslots);
+        n++ {
+        hashtablemigratebucket(h, h.migrated)
+        h.migrated++
+    }
+    if h.migrated == len(h.oldslots) {
+        h.oldslots = nil
+        h.migrated = 0
+    }
+}
+
+
+// Resizes all at once, or starts an incremental resize if the policy asks
+// for one.  A resize already under way is left to finish first.
+func hashtableresize[K any, V comparable](h *HashTable[K, V],
+                                          nslots int) *HashTable[K, V] {
+    if h.policy.incremental == 0 {
+        return HashTableResize(h, nslots)
+    }
+    if h.oldslots != nil {
+        return h
+    }
+    h.oldslots = h.slots
+    h.migrated = 0
+    h.nslots = nslots
+    h.slots = make([][]HashTableEntry[K, V], nslots)
+    for i := range h.slots {
+        h.slots[i] = make([]HashTableEntry[K, V], 0)
+    }
+    return h
+}
+
+
+func hashtableremove[K any, V comparable](h *HashTable[K, V],
+                                          k K) *HashTable[K, V] {
+    hashtablemigrate(h, k)
+    slot := h.hash(k, h.nslots)
+    for i, e := range h.slots[slot] {
+        if h.equal(e.k, k) {
+            h.slots[slot] = remove(h.slots[slot], i)
+            h.nentries--
+            return h
+        }
+    }
+    return h
+}
+
+
+func HashTableRemove[K any, V comparable](h *HashTable[K, V],
+                                          k K) *HashTable[K, V] {
+    h = hashtableremove(h, k)
+    if HashTableLoadFactor(h) < h.policy.shrink &&
+       h.nslots > h.policy.minslots {
+        nslots := int(float32(h.nslots) / h.policy.growth)
+        h = hashtableresize(h, max(nslots, h.policy.minslots))
+    }
+    return h
+}
+
+
+// Shrinks the table to the fewest slots that hold its entries without
+// growing.
+func HashTableShrink[K any, V comparable](h *HashTable[K, V]) *HashTable[K, V] {
+    nslots := h.policy.slotsfor(h.nentries)
+    if nslots < h.nslots {
+        h = HashTableResize(h, nslots)
+    }
+    return h
+}
+
+
+// Grows the table so that it holds n entries without a further resize.
+func HashTableReserve[K any, V comparable](h *HashTable[K, V],
+                                           n int) *HashTable[K, V] {
+    nslots := h.policy.slotsfor(n)
+    if nslots > h.nslots {
+        h = HashTableResize(h, nslots)
+    }
+    return h
+}
+
+
+func HashTableResize[K any, V comparable](h *HashTable[K, V],
+                                          nslots int) *HashTable[K, V] {
+    h2 := hashtablenew[K, V](nslots, h.hash, h.equal, h.policy)
+    slots := hashtableslots(h)
+    for i, _ := range slots {
+        for _, e := range slots[i] {
+            h2 = hashtableset(h2, e.k, e.v)
+        }
+    }
+    return h2
+}
+
+
+func hashtableset[K any, V comparable](h *HashTable[K, V],
+                                       k K, v V) *HashTable[K, V] {
+    hashtablemigrate(h, k)
+    slot := h.hash(k, h.nslots)
+    for i, e := range h.slots[slot] {
+        if h.equal(e.k, k) {
+            h.slots[slot][i].v = v
+            return h
+        }
+    }
+    h.slots[slot] = append(h.slots[slot], HashTableEntry[K, V]{ k, v })
+    h.nentries++
+    return h
+}
+
+
+func HashTableSet[K any, V comparable](h *HashTable[K, V],
+                                       k K, v V) *HashTable[K, V] {
+    h = hashtableset(h, k, v)
+    if HashTableLoadFactor(h) > h.policy.grow {
+        nslots := int(math.Ceil(float64(float32(h.nslots) * h.policy.growth)))
+        h = hashtableresize(h, max(nslots, h.nslots + 1))
+    }
+    return h
+}
+
+
+// A Cache maps keys to entries through a HashTable from hashtable1.go.  The
+// entries are also held by an eviction policy, which chooses the victim when
+// the cache is full.  Entries may have a time to live, checked against the
+// cache's clock when they are read.
+
+
+type CacheEntry[K comparable, V any] struct {
+    k K
+    v V
+    cost int
+    expires time.Time
+    prev *CacheEntry[K, V]
+    next *CacheEntry[K, V]
+    queue int
+    freq int
+    tick int
+    index int
+}
+
+
+type CachePolicy[K comparable, V any] interface {
+    Add(e *CacheEntry[K, V])
+    Access(e *CacheEntry[K, V])
+    Remove(e *CacheEntry[K, V])
+    // Detaches and returns the entry to evict, or nil if there are none.
+    Victim() *CacheEntry[K, V]
+}
+
+
+type CacheEvictionReason int
+
+const (
+    CacheEvicted CacheEvictionReason = iota
+    CacheExpired
+    CacheRemoved
+    CacheReplaced
+)
+
+
+func (r CacheEvictionReason) String() string {
+    return [...]string{ "evicted", "expired", "removed", "replaced" }[r]
+}
+
+
+type CacheStats struct {
+    hits int
+    misses int
+    evictions int
+    expirations int
+}
+
+
+type Cache[K comparable, V any] struct {
+    h *HashTable[K, *CacheEntry[K, V]]
+    policy CachePolicy[K, V]
+    maxentries int
+    maxcost int
+    totalcost int
+    cost func(k K, v V) int
+    ttl time.Duration
+    now func() time.Time
+    onevict func(k K, v V, reason CacheEvictionReason)
+    stats CacheStats
+}
+
+
+type CacheOption[K comparable, V any] func(c *Cache[K, V])
+
+
+// Entries are evicted while their total cost, 1 each by default, is over
+// maxcost.
+func CacheWithMaxCost[K comparable, V any](maxcost int,
+                                           cost func(k K, v V) int) CacheOption[K, V] {
+    return func(c *Cache[K, V]) {
+        c.maxcost = maxcost
+        c.cost = cost
+    }
+}
+
+
+// The time to live given to entries set by Set.
+func CacheWithTTL[K comparable, V any](ttl time.Duration) CacheOption[K, V] {
+    return func(c *Cache[K, V]) {
+        c.ttl = ttl
+    }
+}
+
+
+func CacheWithClock[K comparable, V any](now func() time.Time) CacheOption[K, V] {
+    return func(c *Cache[K, V]) {
+        c.now = now
+    }
+}
+
+
+func CacheWithEvictionCallback[K comparable, V any](f func(k K, v V, reason CacheEvictionReason)) CacheOption[K, V] {
+    return func(c *Cache[K, V]) {
+        c.onevict = f
+    }
+}
+
+
+// A maxentries of 0 or less means no limit on the number of entries, for a
+// cache limited by CacheWithMaxCost alone.
+func CacheNew[K comparable, V any](maxentries int,
+                                   hash func(k K, m int) int,
+                                   policy CachePolicy[K, V],
+                                   options ...CacheOption[K, V]) *Cache[K, V] {
+    c := new(Cache[K, V])
+    c.h = HashTableNew[K, *CacheEntry[K, V]](1, hash)
+    c.policy = policy
+    c.maxentries = maxentries
+    c.cost = func(k K, v V) int { return 1 }
+    c.now = time.Now
+    c.onevict = func(k K, v V, reason CacheEvictionReason) {}
+    for _, option := range options {
+        option(c)
+    }
+    return c
+}
+
+
+func (c *Cache[K, V]) Len() int {
+    return c.h.nentries
+}
+
+
+func (c *Cache[K, V]) Stats() CacheStats {
+    return c.stats
+}
+
+
+func (c *Cache[K, V]) expired(e *CacheEntry[K, V]) bool {
+    return !e.expires.IsZero() && !c.now().Before(e.expires)
+}
+
+
+func (c *Cache[K, V]) remove(e *CacheEntry[K, V], reason CacheEvictionReason) {
+    c.h = HashTableRemove(c.h, e.k)
+    c.totalcost -= e.cost
+    switch reason {
+        case CacheEvicted:
+            c.stats.evictions++
+        case CacheExpired:
+            c.stats.expirations++
+    }
+    c.onevict(e.k, e.v, reason)
+}
+
+
+func (c *Cache[K, V]) Get(k K) (V, bool) {
+    e, ok := HashTableGet(c.h, k)
+    if ok && c.expired(e) {
+        c.policy.Remove(e)
+        c.remove(e, CacheExpired)
+        ok = false
+    }
+    if !ok {
+        c.stats.misses++
+        return *new(V), false
+    }
+    c.stats.hits++
+    c.policy.Access(e)
+    return e.v, true
+}
+
+
+func (c *Cache[K, V]) Set(k K, v V) {
+    c.SetTTL(k, v, c.ttl)
+}
+
+
+// A ttl of 0 means the entry doesn't expire.  Setting a key that's already
+// cached updates its entry in place and counts as an access, so the key
+// keeps its standing with the policy.
+func (c *Cache[K, V]) SetTTL(k K, v V, ttl time.Duration) {
+    var expires time.Time
+    if ttl > 0 {
+        expires = c.now().Add(ttl)
+    }
+    if e, ok := HashTableGet(c.h, k); ok {
+        old := e.v
+        c.totalcost -= e.cost
+        e.v = v
+        e.cost = c.cost(k, v)
+        e.expires = expires
+        c.totalcost += e.cost
+        c.policy.Access(e)
+        c.onevict(k, old, CacheReplaced)
+    } else {
+        e := &CacheEntry[K, V]{ k: k, v: v, cost: c.cost(k, v), expires: expires }
+        c.h = HashTableSet(c.h, k, e)
+        c.totalcost += e.cost
+        c.policy.Add(e)
+    }
+    for c.maxentries > 0 && c.h.nentries > c.maxentries ||
+        c.maxcost > 0 && c.totalcost > c.maxcost {
+        victim := c.policy.Victim()
+        if victim == nil {
+            break
+        }
+        c.remove(victim, CacheEvicted)
+    }
+}
+
+
+func (c *Cache[K, V]) Remove(k K) {
+    if e, ok := HashTableGet(c.h, k); ok {
+        c.policy.Remove(e)
+        c.remove(e, CacheRemoved)
+    }
+}
+
+
+// Expired entries are otherwise only removed when they are read.
+func (c *Cache[K, V]) RemoveExpired() {
+    for _, k := range HashTableKeys(c.h) {
+        e, _ := HashTableGet(c.h, k)
+        if c.expired(e) {
+            c.policy.Remove(e)
+            c.remove(e, CacheExpired)
+        }
+    }
+}
+
+
+// A doubly linked list of entries, most recently added at the front.
+type CacheList[K comparable, V any] struct {
+    front *CacheEntry[K, V]
+    back *CacheEntry[K, V]
+    n int
+}
+
+
+func (l *CacheList[K, V]) pushfront(e *CacheEntry[K, V]) {
+    e.prev = nil
+    e.next = l.front
+    if l.front == nil {
+        l.back = e
+    } else {
+        l.front.prev = e
+    }
+    l.front = e
+    l.n++
+}
+
+
+func (l *CacheList[K, V]) remove(e *CacheEntry[K, V]) {
+    if e.prev == nil {
+        l.front = e.next
+    } else {
+        e.prev.next = e.next
+    }
+    if e.next == nil {
+        l.back = e.prev
+    } else {
+        e.next.prev = e.prev
+    }
+    e.prev = nil
+    e.next = nil
+    l.n--
+}
+
+
+func (l *CacheList[K, V]) popback() *CacheEntry[K, V] {
+    e := l.back
+    if e != nil {
+        l.remove(e)
+    }
+    return e
+}
+
+
+// Least recently used.
+type CacheLRU[K comparable, V any] struct {
+    l CacheList[K, V]
+}
+
+
+func CacheLRUNew[K comparable, V any]() *CacheLRU[K, V] {
+    return new(CacheLRU[K, V])
+}
+
+
+func (p *CacheLRU[K, V]) Add(e *CacheEntry[K, V]) {
+    p.l.pushfront(e)
+}
+
+
+func (p *CacheLRU[K, V]) Access(e *CacheEntry[K, V]) {
+    p.l.remove(e)
+    p.l.pushfront(e)
+}
+
+
+func (p *CacheLRU[K, V]) Remove(e *CacheEntry[K, V]) {
+    p.l.remove(e)
+}
+
+
+func (p *CacheLRU[K, V]) Victim() *CacheEntry[K, V] {
+    return p.l.popback()
+}
+
+
+// Least frequently used, ties going to the least recently used.  Entries
+// are kept in a heap ordered by access count.
+type CacheLFU[K comparable, V any] struct {
+    entries []*CacheEntry[K, V]
+    tick int
+}
+
+
+func CacheLFUNew[K comparable, V any]() *CacheLFU[K, V] {
+    return new(CacheLFU[K, V])
+}
+
+
+func (p *CacheLFU[K, V]) Len() int {
+    return len(p.entries)
+}
+
+
+func (p *CacheLFU[K, V]) Less(i, j int) bool {
+    if p.entries[i].freq != p.entries[j].freq {
+        return p.entries[i].freq < p.entries[j].freq
+    } else {
+        return p.entries[i].tick < p.entries[j].tick
+    }
+}
+
+
+func (p *CacheLFU[K, V]) Swap(i, j int) {
+    p.entries[i], p.entries[j] = p.entries[j], p.entries[i]
+    p.entries[i].index = i
+    p.entries[j].index = j
+}
+
+
+func (p *CacheLFU[K, V]) Push(x any) {
+    e := x.(*CacheEntry[K, V])
+    e.index = len(p.entries)
+    p.entries = append(p.entries, e)
+}
+
+
+func (p *CacheLFU[K, V]) Pop() any {
+    e := p.entries[len(p.entries) - 1]
+    p.entries = p.entries[:len(p.entries) - 1]
+    return e
+}
+
+
+func (p *CacheLFU[K, V]) Add(e *CacheEntry[K, V]) {
+    p.tick++
+    e.freq = 1
+    e.tick = p.tick
+    heap.Push(p, e)
+}
+
+
+func (p *CacheLFU[K, V]) Access(e *CacheEntry[K, V]) {
+    p.tick++
+    e.freq++
+    e.tick = p.tick
+    heap.Fix(p, e.index)
+}
+
+
+func (p *CacheLFU[K, V]) Remove(e *CacheEntry[K, V]) {
+    heap.Remove(p, e.index)
+}
+
+
+func (p *CacheLFU[K, V]) Victim() *CacheEntry[K, V] {
+    if len(p.entries) == 0 {
+        return nil
+    }
+    return heap.Pop(p).(*CacheEntry[K, V])
+}
+
+
+// 2Q: new entries go on a FIFO, a1in, and only move to the LRU list, am, if
+// they are set again soon after being evicted from it, which is tracked by
+// remembering the keys of recent a1in victims in a1out.  A scan of keys used
+// once therefore can't flush the frequently used ones.
+type Cache2Q[K comparable, V any] struct {
+    a1in CacheList[K, V]
+    am CacheList[K, V]
+    a1out *HashTable[K, bool]
+    a1outkeys []K
+    kin int
+    kout int
+}
+
+
+const (
+    cache2qa1in = iota
+    cache2qam
+)
+
+
+// kin is the most entries to keep on a1in while am is non-empty, and kout
+// the number of a1in victims to remember; 1/4 and 1/2 of the cache size are
+// typical.
+func Cache2QNew[K comparable, V any](kin int,
+                                     kout int,
+                                     hash func(k K, m int) int) *Cache2Q[K, V] {
+    p := new(Cache2Q[K, V])
+    p.a1out = HashTableNew[K, bool](1, hash)
+    p.a1outkeys = make([]K, 0, kout)
+    p.kin = kin
+    p.kout = kout
+    return p
+}
+
+
+func (p *Cache2Q[K, V]) Add(e *CacheEntry[K, V]) {
+    if _, ok := HashTableGet(p.a1out, e.k); ok {
+        e.queue = cache2qam
+        p.am.pushfront(e)
+    } else {
+        e.queue = cache2qa1in
+        p.a1in.pushfront(e)
+    }
+}
+
+
+func (p *Cache2Q[K, V]) Access(e *CacheEntry[K, V]) {
+    if e.queue == cache2qam {
+        p.am.remove(e)
+        p.am.pushfront(e)
+    }
+}
+
+
+func (p *Cache2Q[K, V]) Remove(e *CacheEntry[K, V]) {
+    if e.queue == cache2qam {
+        p.am.remove(e)
+    } else {
+        p.a1in.remove(e)
+    }
+}
+
+
+func (p *Cache2Q[K, V]) Victim() *CacheEntry[K, V] {
+    if p.a1in.n > p.kin || p.am.n == 0 {
+        e := p.a1in.popback()
+        if e != nil && p.kout > 0 {
+            if len(p.a1outkeys) == p.kout {
+                p.a1out = HashTableRemove(p.a1out, p.a1outkeys[0])
+                p.a1outkeys = p.a1outkeys[1:]
+            }
+            p.a1out = HashTableSet(p.a1out, e.k, true)
+            p.a1outkeys = append(p.a1outkeys, e.k)
+        }
+        return e
+    }
+    return p.am.popback()
+}
+
+
+func hashsumchars(s string, m int) int {
+    sum := 0
+    for _, e := range s {
+        sum += int(e)
+    }
+    return sum % m
+}
+
+
+func main() {
+    elements := []string{ "hydrogen",
+                          "helium",
+                          "lithium",
+                          "beryllium",
+                          "boron",
+                          "carbon",
+                          "nitrogen",
+                          "oxygen",
+                          "fluorine",
+                          "neon" }
+    logevict := func(k string, v int, reason CacheEvictionReason) {
+        fmt.Printf("%v %v %v\n", k, v, reason)
+    }
+
+
+    lru := CacheNew(3,
+                    hashsumchars,
+                    CacheLRUNew[string, int](),
+                    CacheWithEvictionCallback(logevict))
+    for _, e := range elements[:3] {
+        lru.Set(e, len(e))
+    }
+    lru.Get("hydrogen")
+    lru.Set("beryllium", 9)
+    // => helium 6 evicted
+    _, ok := lru.Get("helium")
+    fmt.Printf("%v %v %+v\n", ok, lru.Len(), lru.Stats())
+    // => false 3 {hits:1 misses:1 evictions:1 expirations:0}
+
+
+    lfu := CacheNew(3, hashsumchars, CacheLFUNew[string, int]())
+    for _, e := range elements[:3] {
+        lfu.Set(e, len(e))
+    }
+    lfu.Get("hydrogen")
+    lfu.Get("hydrogen")
+    lfu.Get("helium")
+    lfu.Set("beryllium", 9)
+    lfu.Set("boron", 5)
+    _, ok1 := lfu.Get("hydrogen")
+    _, ok2 := lfu.Get("helium")
+    _, ok3 := lfu.Get("lithium")
+    fmt.Printf("%v %v %v\n", ok1, ok2, ok3)
+    // => true true false
+
+
+    twoq := CacheNew(4,
+                     hashsumchars,
+                     Cache2QNew[string, int](1, 4, hashsumchars))
+    for _, e := range elements[:5] {
+        twoq.Set(e, len(e))
+    }
+    twoq.Set("hydrogen", 8)
+    for _, e := range elements[5:] {
+        twoq.Set(e, len(e))
+    }
+    _, ok = twoq.Get("hydrogen")
+    fmt.Printf("%v %v\n", ok, twoq.Len())
+    // => true 4
+
+    // hydrogen goes to am when set again after its eviction, then its ghost
+    // in a1out is pushed out by helium's.  Setting it once more mustn't
+    // send it back to a1in.
+    policy := Cache2QNew[string, int](1, 1, hashsumchars)
+    twoq = CacheNew(4, hashsumchars, policy)
+    for _, e := range elements[:5] {
+        twoq.Set(e, len(e))
+    }
+    twoq.Set("hydrogen", 8)
+    _, ghost := HashTableGet(policy.a1out, "hydrogen")
+    twoq.Set("hydrogen", 1)
+    e, _ := HashTableGet(twoq.h, "hydrogen")
+    fmt.Printf("%v %v %v %v\n", ghost, e.queue == cache2qam, policy.am.n, e.v)
+    // => false true 1 1
+
+
+    now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+    ttl := CacheNew(10,
+                    hashsumchars,
+                    CacheLRUNew[string, int](),
+                    CacheWithTTL[string, int](time.Minute),
+                    CacheWithClock[string, int](func() time.Time { return now }),
+                    CacheWithEvictionCallback(logevict))
+    ttl.Set("hydrogen", 8)
+    ttl.SetTTL("helium", 6, time.Hour)
+    now = now.Add(2 * time.Minute)
+    _, ok1 = ttl.Get("hydrogen")
+    // => hydrogen 8 expired
+    _, ok2 = ttl.Get("helium")
+    fmt.Printf("%v %v %+v\n", ok1, ok2, ttl.Stats())
+    // => false true {hits:1 misses:1 evictions:0 expirations:1}
+
+
+    costly := CacheNew(0,
+                       hashsumchars,
+                       CacheLRUNew[string, int](),
+                       CacheWithMaxCost(20, func(k string, v int) int {
+                                                return len(k)
+                                            }))
+    for _, e := range elements[:4] {
+        costly.Set(e, len(e))
+    }
+    fmt.Printf("%v %v\n", costly.Len(), costly.totalcost)
+    // => 2 16
+
+
+    os.Exit(0)
+}
@@ -0,0 +1,477 @@
+package main
+
+
+// host$ go build -race concurrenthashtable.go
+// host$ ./concurrenthashtable
+
+
+import "fmt"
+import "math"
+import "os"
+import "sort"
+import "sync"
+
+
+// Entries are spread over a fixed number of shards, each a chaining hash
+// table with its own lock.  A shard grows or shrinks incrementally: the old
+// slot array is kept alongside the new one and a bounded number of buckets
+// is migrated by each operation, so no single operation rehashes a whole
+// shard and readers of other buckets are never held up by a resize.
+
+
+const hashtablehashmodulus = math.MaxInt32
+const hashtablemigratebuckets = 4
+
+
+type HashTableEntry[K, V comparable] struct {
+    k K
+    v V
+}
+
+
+type ConcurrentHashTableShard[K, V comparable] struct {
+    mu sync.RWMutex
+    nentries int
+    minslots int
+    slots [][]HashTableEntry[K, V]
+    oldslots [][]HashTableEntry[K, V]
+    migrated int
+}
+
+
+type ConcurrentHashTable[K, V comparable] struct {
+    shards []*ConcurrentHashTableShard[K, V]
+    hash func(k K, m int) int
+}
+
+
+func ConcurrentHashTableNew[K, V comparable](nshards int,
+                                             nslots int,
+                                             hash func(k K, m int) int) *ConcurrentHashTable[K, V] {
+    h := new(ConcurrentHashTable[K, V])
+    h.shards = make([]*ConcurrentHashTableShard[K, V], nshards)
+    for i := range h.shards {
+        s := new(ConcurrentHashTableShard[K, V])
+        s.minslots = nslots
+        s.slots = make([][]HashTableEntry[K, V], nslots)
+        h.shards[i] = s
+    }
+    h.hash = hash
+    return h
+}
+
+
+// The hash function is asked for a value modulo a large constant, then the
+// low part picks the shard and the remainder picks the slot within it, so
+// that the shard and slot indexes aren't correlated.
+func (h *ConcurrentHashTable[K, V]) locate(k K) (*ConcurrentHashTableShard[K, V], int) {
+    x := h.hash(k, hashtablehashmodulus)
+    return h.shards[x % len(h.shards)], x / len(h.shards)
+}
+
+
+func slotof[K, V comparable](slots [][]HashTableEntry[K, V], x int) int {
+    return x % len(slots)
+}
+
+
+func (s *ConcurrentHashTableShard[K, V]) find(k K, x int) (int, int, bool) {
+    if s.oldslots != nil {
+        slot := slotof(s.oldslots, x)
+        for i, e := range s.oldslots[slot] {
+            if e.k == k {
+                return -1 - slot, i, true
+            }
+        }
+    }
+    slot := slotof(s.slots, x)
+    for i, e := range s.slots[slot] {
+        if e.k == k {
+            return slot, i, true
+        }
+    }
+    return slot, -1, false
+}
+
+
+func (s *ConcurrentHashTableShard[K, V]) get(k K, x int) (V, bool) {
+    slot, i, ok := s.find(k, x)
+    if !ok {
+        return *new(V), false
+    }
+    if slot < 0 {
+        return s.oldslots[-1 - slot][i].v, true
+    }
+    return s.slots[slot][i].v, true
+}
+
+
+func (s *ConcurrentHashTableShard[K, V]) loadfactor() float32 {
+    return float32(s.nentries) / float32(len(s.slots))
+}
+
+
+func (s *ConcurrentHashTableShard[K, V]) migratebucket(h *ConcurrentHashTable[K, V],
+                                                       slot int) {
+    for _, e := range s.oldslots[slot] {
+        x := h.hash(e.k, hashtablehashmodulus) / len(h.shards)
+        slot2 := slotof(s.slots, x)
+        s.slots[slot2] = append(s.slots[slot2], e)
+    }
+    s.oldslots[slot] = nil
+}
+
+
+// Called with the shard's write lock held.  Moves the key's own bucket, so
+// that the subsequent write only has to look in the new slot array, then
+// advances the migration.
+func (s *ConcurrentHashTableShard[K, V]) migrate(h *ConcurrentHashTable[K, V],
+                                                 x int) {
+    if s.oldslots == nil {
+        return
+    }
+    s.migratebucket(h, slotof(s.oldslots, x))
+    s.advance(h)
+}
+
+
+// Called with the shard's write lock held.  Moves up to
+// hashtablemigratebuckets more buckets, in order, and drops the old slot
+// array once they have all been moved.
+func (s *ConcurrentHashTableShard[K, V]) advance(h *ConcurrentHashTable[K, V]) {
+    if s.oldslots == nil {
+        return
+    }
+    for n := 0;
+        n < hashtablemigratebuckets && s.migrated < len(s.oldslots);
+        n++ {
+        s.migratebucket(h, s.migrated)
+        s.migrated++
+    }
+    if s.migrated == len(s.oldslots) {
+        s.oldslots = nil
+        s.migrated = 0
+    }
+}
+
+
+func (s *ConcurrentHashTableShard[K, V]) resize() {
+    if s.oldslots != nil {
+        return
+    }
+    nslots := len(s.slots)
+    if s.loadfactor() > 0.7 {
+        nslots *= 2
+    } else if s.loadfactor() < 0.3 && nslots / 2 >= s.minslots {
+        nslots /= 2
+    } else {
+        return
+    }
+    s.oldslots = s.slots
+    s.slots = make([][]HashTableEntry[K, V], nslots)
+    s.migrated = 0
+}
+
+
+func (s *ConcurrentHashTableShard[K, V]) set(k K, x int, v V) {
+    slot, i, ok := s.find(k, x)
+    if ok {
+        s.slots[slot][i].v = v
+        return
+    }
+    s.slots[slot] = append(s.slots[slot], HashTableEntry[K, V]{ k, v })
+    s.nentries++
+}
+
+
+func (s *ConcurrentHashTableShard[K, V]) remove(k K, x int) {
+    slot, i, ok := s.find(k, x)
+    if ok {
+        s.slots[slot] = remove(s.slots[slot], i)
+        s.nentries--
+    }
+}
+
+
+// Reads only hold the read lock, so can't migrate as they go.  A read that
+// finds a migration under way takes the write lock afterwards to advance it,
+// so that a shard that is only read from still finishes migrating.
+func (h *ConcurrentHashTable[K, V]) Get(k K) (V, bool) {
+    s, x := h.locate(k)
+    s.mu.RLock()
+    v, ok := s.get(k, x)
+    migrating := s.oldslots != nil
+    s.mu.RUnlock()
+    if migrating {
+        s.mu.Lock()
+        s.migrate(h, x)
+        s.mu.Unlock()
+    }
+    return v, ok
+}
+
+
+func (h *ConcurrentHashTable[K, V]) Len() int {
+    n := 0
+    for _, s := range h.shards {
+        s.mu.RLock()
+        n += s.nentries
+        s.mu.RUnlock()
+    }
+    return n
+}
+
+
+// Keys are consistent per shard but not across shards.
+func (h *ConcurrentHashTable[K, V]) Keys() []K {
+    keys := make([]K, 0)
+    for _, s := range h.shards {
+        s.mu.RLock()
+        for _, slots := range [][][]HashTableEntry[K, V]{ s.oldslots, s.slots } {
+            for i, _ := range slots {
+                for _, e := range slots[i] {
+                    keys = append(keys, e.k)
+                }
+            }
+        }
+        migrating := s.oldslots != nil
+        s.mu.RUnlock()
+        if migrating {
+            s.mu.Lock()
+            s.advance(h)
+            s.mu.Unlock()
+        }
+    }
+    return keys
+}
+
+
+func remove[T any](v []T, i int) []T {
+    v[i] = v[len(v) - 1]
+    v = v[:len(v) - 1]
+    return v
+}
+
+
+// f is called with the current value, if any, while the shard is locked.
+// It returns the new value and whether the key should be kept; returning
+// false removes the key.  f must not call back into the table.
+func (h *ConcurrentHashTable[K, V]) Compute(k K,
+                                            f func(v V, ok bool) (V, bool)) (V, bool) {
+    s, x := h.locate(k)
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.migrate(h, x)
+    v, ok := s.get(k, x)
+    v, keep := f(v, ok)
+    if keep {
+        s.set(k, x, v)
+    } else {
+        s.remove(k, x)
+        v = *new(V)
+    }
+    s.resize()
+    return v, keep
+}
+
+
+func (h *ConcurrentHashTable[K, V]) Set(k K, v V) {
+    h.Compute(k, func(_ V, _ bool) (V, bool) {
+                     return v, true
+                 })
+}
+
+
+func (h *ConcurrentHashTable[K, V]) Remove(k K) {
+    h.Compute(k, func(v V, _ bool) (V, bool) {
+                     return v, false
+                 })
+}
+
+
+// Applies f to the value only if the key is present.
+func (h *ConcurrentHashTable[K, V]) Update(k K, f func(v V) V) bool {
+    updated := false
+    h.Compute(k, func(v V, ok bool) (V, bool) {
+                     if ok {
+                         v = f(v)
+                         updated = true
+                     }
+                     return v, ok
+                 })
+    return updated
+}
+
+
+func (h *ConcurrentHashTable[K, V]) LoadOrStore(k K, v V) (V, bool) {
+    loaded := false
+    actual, _ := h.Compute(k, func(v1 V, ok bool) (V, bool) {
+                                  if ok {
+                                      loaded = true
+                                      return v1, true
+                                  }
+                                  return v, true
+                              })
+    return actual, loaded
+}
+
+
+func (h *ConcurrentHashTable[K, V]) CompareAndSwap(k K, old, new V) bool {
+    swapped := false
+    h.Compute(k, func(v V, ok bool) (V, bool) {
+                     if ok && v == old {
+                         swapped = true
+                         return new, true
+                     }
+                     return v, ok
+                 })
+    return swapped
+}
+
+
+func hashsumchars(s string, m int) int {
+    sum := 0
+    for _, e := range s {
+        sum += int(e)
+    }
+    return sum % m
+}
+
+
+func hashint(i int, m int) int {
+    x := uint64(i) * 0x9e3779b97f4a7c15
+    return int((x ^ (x >> 32)) % uint64(m))
+}
+
+
+func main() {
+    elements := []string{ "hydrogen",
+                          "helium",
+                          "lithium",
+                          "beryllium",
+                          "boron",
+                          "carbon",
+                          "nitrogen",
+                          "oxygen",
+                          "fluorine",
+                          "neon",
+                          "sodium",
+                          "magnesium",
+                          "aluminium",
+                          "silicon",
+                          "phosphorus",
+                          "sulfur",
+                          "chlorine",
+                          "argon",
+                          "potassium",
+                          "calcium" }
+    hashtable := ConcurrentHashTableNew[string, int](4, 1, hashsumchars)
+
+
+    var wg sync.WaitGroup
+    for _, e := range elements {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            hashtable.Set(e, len(e))
+        }()
+    }
+    wg.Wait()
+
+    keys := hashtable.Keys()
+    sort.Strings(keys)
+    fmt.Printf("%v %v\n", hashtable.Len(), keys)
+    // => 20 [aluminium argon beryllium boron calcium ...]
+
+    v, ok := hashtable.Get("oxygen")
+    fmt.Printf("%v %v\n", v, ok)
+    // => 6 true
+
+    v, ok = hashtable.LoadOrStore("oxygen", 0)
+    fmt.Printf("%v %v\n", v, ok)
+    // => 6 true
+
+    fmt.Printf("%v %v\n",
+               hashtable.CompareAndSwap("oxygen", 0, 8),
+               hashtable.CompareAndSwap("oxygen", 6, 8))
+    // => false true
+
+    fmt.Printf("%v %v\n",
+               hashtable.Update("oxygen", func(v int) int { return v * 10 }),
+               hashtable.Update("xenon", func(v int) int { return v * 10 }))
+    // => true false
+
+    v, ok = hashtable.Get("oxygen")
+    fmt.Printf("%v %v\n", v, ok)
+    // => 80 true
+
+
+    for _, e := range hashtable.Keys() {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            hashtable.Remove(e)
+        }()
+    }
+    wg.Wait()
+
+    v, ok = hashtable.Get("oxygen")
+    fmt.Printf("%v %v %v\n", hashtable.Len(), v, ok)
+    // => 0 0 false
+
+
+    counts := ConcurrentHashTableNew[int, int](16, 1, hashint)
+    for g := 0; g < 8; g++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for i := 0; i < 10000; i++ {
+                counts.Compute(i % 1000, func(v int, _ bool) (int, bool) {
+                                             return v + 1, true
+                                         })
+                counts.Get(i % 1000)
+            }
+        }()
+    }
+    wg.Wait()
+
+    total := 0
+    for _, k := range counts.Keys() {
+        v, _ := counts.Get(k)
+        total += v
+    }
+    fmt.Printf("%v %v\n", counts.Len(), total)
+    // => 1000 80000
+
+    migrating := func() int {
+        n := 0
+        for _, s := range counts.shards {
+            if s.oldslots != nil {
+                n++
+            }
+        }
+        return n
+    }
+    // The removals leave every shard part way through shrinking, which reads
+    // alone then finish.
+    for i := 0; i < 900; i++ {
+        counts.Remove(i)
+    }
+    fmt.Printf("%v %v\n", counts.Len(), migrating())
+    // => 100 16
+    for i := 0; i < 20; i++ {
+        for k := 900; k < 1000; k++ {
+            wg.Add(1)
+            go func() {
+                defer wg.Done()
+                counts.Get(k)
+            }()
+        }
+        wg.Wait()
+    }
+    fmt.Printf("%v %v\n", counts.Len(), migrating())
+    // => 100 0
+
+
+    os.Exit(0)
+}
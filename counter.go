@@ -0,0 +1,611 @@
+package main
+
+
+// host$ go build counter.go
+// host$ ./counter prideandprejudice.txt
+
+
+import "fmt"
+import "math"
+import "os"
+import "regexp"
+import "sort"
+import "strings"
+
+
+type Ordered interface {
+    ~int | ~int8 | ~int16 | ~int32 | ~int64 |
+    ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 |
+    ~uintptr | ~float32 | ~float64 | ~string
+}
+
+
+func Compare[A Ordered](x, y A) int {
+    booltoint := func (b bool) int {
+        if b {
+            return 1
+        } else {
+            return 0
+        }
+    }
+
+    return booltoint(x > y) - booltoint(x < y)
+}
+
+
+type HashTableEntry[K any, V comparable] struct {
+    k K
+    v V
+}
+
+
+// The table grows by growth when the load factor rises above grow, and
+// shrinks by the same factor when it falls below shrink, but never below
+// minslots.  shrink * growth must be less than grow so that a table that has
+// just shrunk can't immediately grow again, and vice versa.
+type HashTablePolicy struct {
+    grow float32
+    shrink float32
+    growth float32
+    minslots int
+    expected int
+    incremental int
+}
+
+
+type HashTableOption func(p *HashTablePolicy)
+
+
+func HashTableWithThresholds(grow, shrink float32) HashTableOption {
+    return func(p *HashTablePolicy) {
+        p.grow = grow
+        p.shrink = shrink
+    }
+}
+
+
+func HashTableWithGrowthFactor(growth float32) HashTableOption {
+    return func(p *HashTablePolicy) {
+        p.growth = growth
+    }
+}
+
+
+func HashTableWithMinSlots(minslots int) HashTableOption {
+    return func(p *HashTablePolicy) {
+        p.minslots = minslots
+    }
+}
+
+
+// Instead of rehashing every entry at once, a resize keeps the old slots
+// alongside the new ones and each Set or Remove moves the key's own old
+// bucket plus up to nbuckets more, bounding the cost of any one call.
+// nbuckets needs to be at least 1 / ((growth - 1) * grow), 2 by default, for
+// each migration to finish before the next resize is due.
+func HashTableWithIncrementalResize(nbuckets int) HashTableOption {
+    return func(p *HashTablePolicy) {
+        p.incremental = nbuckets
+    }
+}
+
+
+// Pre-sizes the table so that n entries can be set without a resize.
+func HashTableWithExpected(n int) HashTableOption {
+    return func(p *HashTablePolicy) {
+        p.expected = n
+    }
+}
+
+
+type HashTable[K any, V comparable] struct {
+    nentries int
+    nslots int
+    slots [][]HashTableEntry[K, V]
+    hash func(k K, m int) int
+    equal func(x, y K) bool
+    policy HashTablePolicy
+    oldslots [][]HashTableEntry[K, V]
+    migrated int
+}
+
+
+func hashtablenew[K any, V comparable](nslots int,
+                                       hash func(k K, m int) int,
+                                       equal func(x, y K) bool,
+                                       policy HashTablePolicy) *HashTable[K, V] {
+    h := new(HashTable[K, V])
+    h.nentries = 0
+    h.nslots = nslots
+    h.slots = make([][]HashTableEntry[K, V], nslots)
+    for i := range h.slots {
+        h.slots[i] = make([]HashTableEntry[K, V], 0)
+    }
+    h.hash = hash
+    h.equal = equal
+    h.policy = policy
+    return h
+}
+
+
+func HashTableNew[K, V comparable](nslots int,
+                                   hash func(k K, m int) int,
+                                   options ...HashTableOption) *HashTable[K, V] {
+    return HashTableNewFunc[K, V](nslots,
+                                  hash,
+                                  func(x, y K) bool { return x == y },
+                                  options...)
+}
+
+
+// For keys that aren't comparable, or that need comparing other than with
+// ==, such as case-insensitive strings.  Keys that are equal must hash to
+// the same slot.
+func HashTableNewFunc[K any, V comparable](nslots int,
+                                           hash func(k K, m int) int,
+                                           equal func(x, y K) bool,
+                                           options ...HashTableOption) *HashTable[K, V] {
+    policy := HashTablePolicy{ 0.7, 0.3, 2, nslots, 0, 0 }
+    for _, option := range options {
+        option(&policy)
+    }
+    if policy.minslots < 1 ||
+       policy.growth <= 1 ||
+       policy.grow <= 0 ||
+       policy.shrink < 0 ||
+       policy.incremental < 0 ||
+       policy.shrink * policy.growth >= policy.grow {
+        panic(fmt.Sprintf("HashTableNew: invalid policy %+v", policy))
+    }
+    nslots = max(nslots, policy.minslots, policy.slotsfor(policy.expected))
+    return hashtablenew[K, V](nslots, hash, equal, policy)
+}
+
+
+type Hasher[K any] interface {
+    Hash(k K, m int) int
+    Equal(x, y K) bool
+}
+
+
+func HashTableNewHasher[K any, V comparable](nslots int,
+                                             hasher Hasher[K],
+                                             options ...HashTableOption) *HashTable[K, V] {
+    return HashTableNewFunc[K, V](nslots, hasher.Hash, hasher.Equal, options...)
+}
+
+
+// The number of slots needed to hold n entries without growing.
+func (p HashTablePolicy) slotsfor(n int) int {
+    return max(p.minslots, int(math.Ceil(float64(float32(n) / p.grow))))
+}
+
+
+func HashTableGet[K any, V comparable](h *HashTable[K, V], k K) (V, bool) {
+    if h.oldslots != nil {
+        for _, e := range h.oldslots[h.hash(k, len(h.oldslots))] {
+            if h.equal(e.k, k) {
+                return e.v, true
+            }
+        }
+    }
+    slot := h.hash(k, h.nslots)
+    for _, e := range h.slots[slot] {
+        if h.equal(e.k, k) {
+            return e.v, true
+        }
+    }
+    return *new(V), false
+}
+
+
+func HashTableKeys[K any, V comparable](h *HashTable[K, V]) []K {
+    keys := make([]K, 0, h.nentries)
+    slots := hashtableslots(h)
+    for i, _ := range slots {
+        for _, e := range slots[i] {
+            keys = append(keys, e.k)
+        }
+    }
+    return keys
+}
+
+
+// All buckets, including those still waiting to be migrated.
+func hashtableslots[K any, V comparable](h *HashTable[K, V]) [][]HashTableEntry[K, V] {
+    if h.oldslots == nil {
+        return h.slots
+    }
+    return append(h.oldslots[:len(h.oldslots):len(h.oldslots)], h.slots...)
+}
+
+
+func HashTableLoadFactor[K any, V comparable](h *HashTable[K, V]) float32 {
+    return float32(h.nentries) / float32(h.nslots)
+}
+
+
+func remove[T any](v []T, i int) []T {
+    v[i] = v[len(v) - 1]
+    v = v[:len(v) - 1]
+    return v
+}
+
+
+func hashtablemigratebucket[K any, V comparable](h *HashTable[K, V], slot int) {
+    for _, e := range h.oldslots[slot] {
+        slot2 := h.hash(e.k, h.nslots)
+        h.slots[slot2] = append(h.slots[slot2], e)
+    }
+    h.oldslots[slot] = nil
+}
+
+
+// Moves k's old bucket, so that it only needs looking for in the new slots,
+// then moves up to policy.incremental more.
+func hashtablemigrate[K any, V comparable](h *HashTable[K, V], k K) {
+    if h.oldslots == nil {
+        return
+    }
+    hashtablemigratebucket(h, h.hash(k, len(h.oldslots)))
+    for n := 0;
+        n < h.policy.incremental && h.migrated < len(h.oldslots);
+        n++ {
+        hashtablemigratebucket(h, h.migrated)
+        h.migrated++
+    }
+    if h.migrated == len(h.oldslots) {
+        h.oldslots = nil
+        h.migrated = 0
+    }
+}
+
+
+// Resizes all at once, or starts an incremental resize if the policy asks
+// for one.  A resize already under way is left to finish first.
+func hashtableresize[K any, V comparable](h *HashTable[K, V],
+                                          nslots int) *HashTable[K, V] {
+    if h.policy.incremental == 0 {
+        return HashTableResize(h, nslots)
+    }
+    if h.oldslots != nil {
+        return h
+    }
+    h.oldslots = h.slots
+    h.migrated = 0
+    h.nslots = nslots
+    h.slots = make([][]HashTableEntry[K, V], nslots)
+    for i := range h.slots {
+        h.slots[i] = make([]HashTableEntry[K, V], 0)
+    }
+    return h
+}
+
+
+func hashtableremove[K any, V comparable](h *HashTable[K, V],
+                                          k K) *HashTable[K, V] {
+    hashtablemigrate(h, k)
+    slot := h.hash(k, h.nslots)
+    for i, e := range h.slots[slot] {
+        if h.equal(e.k, k) {
+            h.slots[slot] = remove(h.slots[slot], i)
+            h.nentries--
+            return h
+        }
+    }
+    return h
+}
+
+
+func HashTableRemove[K any, V comparable](h *HashTable[K, V],
+                                          k K) *HashTable[K, V] {
+    h = hashtableremove(h, k)
+    if HashTableLoadFactor(h) < h.policy.shrink &&
+       h.nslots > h.policy.minslots {
+        nslots := int(float32(h.nslots) / h.policy.growth)
+        h = hashtableresize(h, max(nslots, h.policy.minslots))
+    }
+    return h
+}
+
+
+// Shrinks the table to the fewest slots that hold its entries without
+// growing.
+func HashTableShrink[K any, V comparable](h *HashTable[K, V]) *HashTable[K, V] {
+    nslots := h.policy.slotsfor(h.nentries)
+    if nslots < h.nslots {
+        h = HashTableResize(h, nslots)
+    }
+    return h
+}
+
+
+// Grows the table so that it holds n entries without a further resize.
+func HashTableReserve[K any, V comparable](h *HashTable[K, V],
+                                           n int) *HashTable[K, V] {
+    nslots := h.policy.slotsfor(n)
+    if nslots > h.nslots {
+        h = HashTableResize(h, nslots)
+    }
+    return h
+}
+
+
+func HashTableResize[K any, V comparable](h *HashTable[K, V],
+                                          nslots int) *HashTable[K, V] {
+    h2 := hashtablenew[K, V](nslots, h.hash, h.equal, h.policy)
+    slots := hashtableslots(h)
+    for i, _ := range slots {
+        for _, e := range slots[i] {
+            h2 = hashtableset(h2, e.k, e.v)
+        }
+    }
+    return h2
+}
+
+
+func hashtableset[K any, V comparable](h *HashTable[K, V],
+                                       k K, v V) *HashTable[K, V] {
+    hashtablemigrate(h, k)
+    slot := h.hash(k, h.nslots)
+    for i, e := range h.slots[slot] {
+        if h.equal(e.k, k) {
+            h.slots[slot][i].v = v
+            return h
+        }
+    }
+    h.slots[slot] = append(h.slots[slot], HashTableEntry[K, V]{ k, v })
+    h.nentries++
+    return h
+}
+
+
+func HashTableSet[K any, V comparable](h *HashTable[K, V],
+                                       k K, v V) *HashTable[K, V] {
+    h = hashtableset(h, k, v)
+    if HashTableLoadFactor(h) > h.policy.grow {
+        nslots := int(math.Ceil(float64(float32(h.nslots) * h.policy.growth)))
+        h = hashtableresize(h, max(nslots, h.nslots + 1))
+    }
+    return h
+}
+
+
+// A multiset: a HashTable from hashtable1.go of keys to positive counts.
+type Counter[K comparable] struct {
+    h *HashTable[K, int]
+}
+
+
+type CounterEntry[K comparable] struct {
+    k K
+    count int
+}
+
+
+func CounterNew[K comparable](hash func(k K, m int) int) *Counter[K] {
+    c := new(Counter[K])
+    c.h = HashTableNew[K, int](1, hash)
+    return c
+}
+
+
+func CounterOf[K comparable](hash func(k K, m int) int, ks ...K) *Counter[K] {
+    c := CounterNew(hash)
+    for _, k := range ks {
+        c.Add(k, 1)
+    }
+    return c
+}
+
+
+func (c *Counter[K]) Count(k K) int {
+    n, _ := HashTableGet(c.h, k)
+    return n
+}
+
+
+// Adds n to k's count, which may be negative.  Keys whose count falls to 0
+// or below are removed.
+func (c *Counter[K]) Add(k K, n int) *Counter[K] {
+    n += c.Count(k)
+    if n > 0 {
+        c.h = HashTableSet(c.h, k, n)
+    } else {
+        c.h = HashTableRemove(c.h, k)
+    }
+    return c
+}
+
+
+func (c *Counter[K]) Merge(c2 *Counter[K]) *Counter[K] {
+    for _, k := range HashTableKeys(c2.h) {
+        c.Add(k, c2.Count(k))
+    }
+    return c
+}
+
+
+func (c *Counter[K]) Subtract(c2 *Counter[K]) *Counter[K] {
+    for _, k := range HashTableKeys(c2.h) {
+        c.Add(k, -c2.Count(k))
+    }
+    return c
+}
+
+
+// The number of distinct keys.
+func (c *Counter[K]) Len() int {
+    return c.h.nentries
+}
+
+
+func (c *Counter[K]) Total() int {
+    total := 0
+    for _, k := range HashTableKeys(c.h) {
+        total += c.Count(k)
+    }
+    return total
+}
+
+
+// The n highest counts, or all of them if n is negative, ties being ordered
+// by compare on the keys.
+func (c *Counter[K]) MostCommon(n int,
+                                compare func(x, y K) int) []CounterEntry[K] {
+    l := make([]CounterEntry[K], 0, c.h.nentries)
+    for _, k := range HashTableKeys(c.h) {
+        l = append(l, CounterEntry[K]{ k, c.Count(k) })
+    }
+    sort.Slice(l, func(i, j int) bool {
+                      if l[i].count != l[j].count {
+                          return l[i].count > l[j].count
+                      } else {
+                          return compare(l[i].k, l[j].k) < 0
+                      }
+                  })
+    if n >= 0 && n < len(l) {
+        l = l[:n]
+    }
+    return l
+}
+
+
+// Multiple values per key, in the order they were added.  The table holds a
+// pointer to each key's values so that adding to them doesn't mean setting
+// the key again.
+type MultiMap[K, V comparable] struct {
+    h *HashTable[K, *[]V]
+    nvalues int
+}
+
+
+func MultiMapNew[K, V comparable](hash func(k K, m int) int) *MultiMap[K, V] {
+    m := new(MultiMap[K, V])
+    m.h = HashTableNew[K, *[]V](1, hash)
+    return m
+}
+
+
+func (m *MultiMap[K, V]) Get(k K) []V {
+    vs, ok := HashTableGet(m.h, k)
+    if !ok {
+        return nil
+    }
+    return *vs
+}
+
+
+func (m *MultiMap[K, V]) Add(k K, v V) *MultiMap[K, V] {
+    vs, ok := HashTableGet(m.h, k)
+    if !ok {
+        vs = new([]V)
+        m.h = HashTableSet(m.h, k, vs)
+    }
+    *vs = append(*vs, v)
+    m.nvalues++
+    return m
+}
+
+
+// Removes the first occurrence of v from k's values.
+func (m *MultiMap[K, V]) RemoveValue(k K, v V) *MultiMap[K, V] {
+    vs, ok := HashTableGet(m.h, k)
+    if !ok {
+        return m
+    }
+    for i, e := range *vs {
+        if e == v {
+            *vs = append((*vs)[:i], (*vs)[i + 1:]...)
+            m.nvalues--
+            break
+        }
+    }
+    if len(*vs) == 0 {
+        m.h = HashTableRemove(m.h, k)
+    }
+    return m
+}
+
+
+func (m *MultiMap[K, V]) Remove(k K) *MultiMap[K, V] {
+    m.nvalues -= len(m.Get(k))
+    m.h = HashTableRemove(m.h, k)
+    return m
+}
+
+
+func (m *MultiMap[K, V]) Keys() []K {
+    return HashTableKeys(m.h)
+}
+
+
+// The number of distinct keys and the total number of values.
+func (m *MultiMap[K, V]) Len() (int, int) {
+    return m.h.nentries, m.nvalues
+}
+
+
+func hashsumchars(s string, m int) int {
+    sum := 0
+    for _, e := range s {
+        sum += int(e)
+    }
+    return sum % m
+}
+
+
+func Words(s string) []string {
+    s = strings.ToLower(s)
+    s = regexp.MustCompile("'s").ReplaceAllString(s, "")
+    s = regexp.MustCompile("\\W").ReplaceAllString(s, " ")
+    return strings.Fields(s)
+}
+
+
+func main() {
+    c := CounterOf(hashsumchars, Words("It is a truth universally " +
+                                       "acknowledged, that a single man in " +
+                                       "possession of a good fortune, must " +
+                                       "be in want of a wife.")...)
+    fmt.Printf("%v %v %v\n", c.Len(), c.Total(), c.Count("a"))
+    // => 18 23 4
+    fmt.Printf("%v\n", c.MostCommon(3, Compare[string]))
+    // => [{a 4} {in 2} {of 2}]
+
+    c.Subtract(CounterOf(hashsumchars, "a", "a", "in", "in", "wife"))
+    fmt.Printf("%v %v\n", c.MostCommon(2, Compare[string]), c.Count("wife"))
+    // => [{a 2} {of 2}] 0
+    c.Merge(CounterOf(hashsumchars, "wife", "wife"))
+    fmt.Printf("%v\n", c.MostCommon(3, Compare[string]))
+    // => [{a 2} {of 2} {wife 2}]
+
+
+    m := MultiMapNew[int, string](func(k int, m int) int { return k % m })
+    for _, e := range []string{ "hydrogen", "helium", "lithium", "boron",
+                                "carbon", "nitrogen", "oxygen", "neon" } {
+        m.Add(len(e), e)
+    }
+    fmt.Printf("%v %v\n", m.Get(6), m.Get(8))
+    // => [helium carbon oxygen] [hydrogen nitrogen]
+    m.RemoveValue(6, "carbon").Remove(8)
+    nkeys, nvalues := m.Len()
+    fmt.Printf("%v %v %v\n", m.Get(6), nkeys, nvalues)
+    // => [helium oxygen] 4 5
+
+
+    for _, a := range os.Args[1:] {
+        bytes, err := os.ReadFile(a)
+        if err != nil {
+            fmt.Println("Error")
+            os.Exit(1)
+        }
+        for _, e := range CounterOf(hashsumchars, Words(string(bytes))...).
+                              MostCommon(-1, Compare[string]) {
+            fmt.Printf("%v %v\n", e.k, e.count)
+        }
+    }
+
+
+    os.Exit(0)
+}
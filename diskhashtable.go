@@ -0,0 +1,756 @@
+package main
+
+
+// host$ go build diskhashtable.go
+// host$ ./diskhashtable
+
+
+import "bytes"
+import "encoding/binary"
+import "errors"
+import "fmt"
+import "hash/crc32"
+import "io"
+import "os"
+import "path/filepath"
+import "strconv"
+
+
+// A chaining hash table kept in a file of fixed size pages, so that it can
+// outgrow memory.  Page 0 is the header, followed by a directory holding the
+// first bucket page of each slot, followed by bucket pages, each holding as
+// many entries as fit and the number of the next page in its chain:
+//
+//     header:    magic "DHTB" | version uint16 | nslots uint64 |
+//                nentries uint64 | npages uint64 | directory uint64 | crc32
+//     directory: nslots * page uint64, 0 for an empty slot
+//     bucket:    next uint64 | nused uint16 |
+//                (klen uint16 | vlen uint16 | k | v)*
+//
+// Every change is a batch of whole pages.  The batch is written to a
+// write-ahead log and synced before the pages are written in place, and the
+// log is truncated once they have been synced too.  If the process dies part
+// way through, opening the table replays a complete log and discards an
+// incomplete one, so a change is either wholly applied or not at all.
+//
+// Resize writes a new directory and new bucket chains after the end of the
+// file, rehashing one old chain at a time, then switches the header to them.
+// The old pages aren't reused.  Nor are pages ever freed: Remove leaves an
+// emptied page in its chain, where later entries for that slot can use it,
+// so the file never shrinks, and the only way to compact it is to copy the
+// entries to a new table.
+
+
+const diskhashtablepagesize = 4096
+const diskhashtablemagic = "DHTB"
+const diskhashtableversion = 1
+const diskhashtablewalmagic = 0x4448574c
+const diskhashtableresizebatch = 64
+
+
+type DiskCodec[A any] interface {
+    Encode(a A) []byte
+    Decode(b []byte) (A, error)
+}
+
+
+type StringCodec struct{}
+
+
+func (StringCodec) Encode(s string) []byte {
+    return []byte(s)
+}
+
+
+func (StringCodec) Decode(b []byte) (string, error) {
+    return string(b), nil
+}
+
+
+type IntCodec struct{}
+
+
+func (IntCodec) Encode(i int) []byte {
+    return binary.AppendVarint(nil, int64(i))
+}
+
+
+func (IntCodec) Decode(b []byte) (int, error) {
+    i, n := binary.Varint(b)
+    if n <= 0 {
+        return 0, errors.New("IntCodec: bad varint")
+    }
+    return int(i), nil
+}
+
+
+type DiskHashTable[K, V comparable] struct {
+    f *os.File
+    wal *os.File
+    nentries int
+    nslots int
+    npages int
+    directory int
+    hash func(k K, m int) int
+    kcodec DiskCodec[K]
+    vcodec DiskCodec[V]
+}
+
+
+type DiskHashTableEntry struct {
+    k []byte
+    v []byte
+}
+
+
+type DiskHashTablePage struct {
+    next int
+    entries []DiskHashTableEntry
+}
+
+
+// Opens the table in path, creating it with nslots slots if it doesn't
+// exist, and recovers from the write-ahead log if need be.
+func DiskHashTableOpen[K, V comparable](path string,
+                                        nslots int,
+                                        hash func(k K, m int) int,
+                                        kcodec DiskCodec[K],
+                                        vcodec DiskCodec[V]) (*DiskHashTable[K, V], error) {
+    f, err := os.OpenFile(path, os.O_RDWR | os.O_CREATE, 0644)
+    if err != nil {
+        return nil, err
+    }
+    wal, err := os.OpenFile(path + ".wal", os.O_RDWR | os.O_CREATE, 0644)
+    if err != nil {
+        f.Close()
+        return nil, err
+    }
+    h := &DiskHashTable[K, V]{ f: f,
+                               wal: wal,
+                               hash: hash,
+                               kcodec: kcodec,
+                               vcodec: vcodec }
+    err = h.open(nslots)
+    if err != nil {
+        h.Close()
+        return nil, err
+    }
+    return h, nil
+}
+
+
+func (h *DiskHashTable[K, V]) open(nslots int) error {
+    if err := h.recover(); err != nil {
+        return err
+    }
+    info, err := h.f.Stat()
+    if err != nil {
+        return err
+    }
+    if info.Size() == 0 {
+        h.nslots = nslots
+        h.directory = 1
+        h.npages = 1 + diskhashtabledirpages(nslots)
+        batch := make(map[int][]byte)
+        for i := 0; i < diskhashtabledirpages(nslots); i++ {
+            batch[h.directory + i] = make([]byte, diskhashtablepagesize)
+        }
+        return h.commit(batch)
+    }
+    return h.readheader()
+}
+
+
+func (h *DiskHashTable[K, V]) Close() error {
+    err1 := h.f.Close()
+    err2 := h.wal.Close()
+    return errors.Join(err1, err2)
+}
+
+
+func (h *DiskHashTable[K, V]) Len() int {
+    return h.nentries
+}
+
+
+func (h *DiskHashTable[K, V]) LoadFactor() float32 {
+    return float32(h.nentries) / float32(h.nslots)
+}
+
+
+func diskhashtabledirpages(nslots int) int {
+    return (nslots * 8 + diskhashtablepagesize - 1) / diskhashtablepagesize
+}
+
+
+func (h *DiskHashTable[K, V]) header() []byte {
+    var b bytes.Buffer
+    b.WriteString(diskhashtablemagic)
+    binary.Write(&b, binary.BigEndian, uint16(diskhashtableversion))
+    binary.Write(&b, binary.BigEndian, uint64(h.nslots))
+    binary.Write(&b, binary.BigEndian, uint64(h.nentries))
+    binary.Write(&b, binary.BigEndian, uint64(h.npages))
+    binary.Write(&b, binary.BigEndian, uint64(h.directory))
+    binary.Write(&b, binary.BigEndian, crc32.ChecksumIEEE(b.Bytes()))
+    page := make([]byte, diskhashtablepagesize)
+    copy(page, b.Bytes())
+    return page
+}
+
+
+func (h *DiskHashTable[K, V]) readheader() error {
+    page, err := h.readpage(0)
+    if err != nil {
+        return err
+    }
+    if string(page[:4]) != diskhashtablemagic {
+        return errors.New("DiskHashTable: not a table")
+    }
+    if binary.BigEndian.Uint16(page[4:]) != diskhashtableversion {
+        return errors.New("DiskHashTable: unsupported version")
+    }
+    if binary.BigEndian.Uint32(page[38:]) != crc32.ChecksumIEEE(page[:38]) {
+        return errors.New("DiskHashTable: header checksum mismatch")
+    }
+    h.nslots = int(binary.BigEndian.Uint64(page[6:]))
+    h.nentries = int(binary.BigEndian.Uint64(page[14:]))
+    h.npages = int(binary.BigEndian.Uint64(page[22:]))
+    h.directory = int(binary.BigEndian.Uint64(page[30:]))
+    return nil
+}
+
+
+func (h *DiskHashTable[K, V]) readpage(n int) ([]byte, error) {
+    page := make([]byte, diskhashtablepagesize)
+    _, err := h.f.ReadAt(page, int64(n) * diskhashtablepagesize)
+    return page, err
+}
+
+
+func decodepage(page []byte) (DiskHashTablePage, error) {
+    var p DiskHashTablePage
+    p.next = int(binary.BigEndian.Uint64(page))
+    nused := int(binary.BigEndian.Uint16(page[8:]))
+    if 10 + nused > len(page) {
+        return p, errors.New("DiskHashTable: corrupt page")
+    }
+    b := page[10:10 + nused]
+    for len(b) > 0 {
+        if len(b) < 4 {
+            return p, errors.New("DiskHashTable: corrupt page")
+        }
+        klen := int(binary.BigEndian.Uint16(b))
+        vlen := int(binary.BigEndian.Uint16(b[2:]))
+        if 4 + klen + vlen > len(b) {
+            return p, errors.New("DiskHashTable: corrupt page")
+        }
+        p.entries = append(p.entries,
+                           DiskHashTableEntry{ b[4:4 + klen],
+                                               b[4 + klen:4 + klen + vlen] })
+        b = b[4 + klen + vlen:]
+    }
+    return p, nil
+}
+
+
+func (p DiskHashTablePage) size() int {
+    n := 10
+    for _, e := range p.entries {
+        n += 4 + len(e.k) + len(e.v)
+    }
+    return n
+}
+
+
+func (p DiskHashTablePage) encode() []byte {
+    page := make([]byte, diskhashtablepagesize)
+    binary.BigEndian.PutUint64(page, uint64(p.next))
+    binary.BigEndian.PutUint16(page[8:], uint16(p.size() - 10))
+    i := 10
+    for _, e := range p.entries {
+        binary.BigEndian.PutUint16(page[i:], uint16(len(e.k)))
+        binary.BigEndian.PutUint16(page[i + 2:], uint16(len(e.v)))
+        i += 4
+        i += copy(page[i:], e.k)
+        i += copy(page[i:], e.v)
+    }
+    return page
+}
+
+
+// The page holding directory entry slot, and the entry's offset in it.
+func (h *DiskHashTable[K, V]) dirlocation(directory, slot int) (int, int) {
+    return directory + slot * 8 / diskhashtablepagesize,
+           slot * 8 % diskhashtablepagesize
+}
+
+
+// Reads a page, preferring its copy in batch if it has one.
+func (h *DiskHashTable[K, V]) batchpage(batch map[int][]byte,
+                                        n int) ([]byte, error) {
+    if page, ok := batch[n]; ok {
+        return page, nil
+    }
+    return h.readpage(n)
+}
+
+
+func (h *DiskHashTable[K, V]) first(batch map[int][]byte,
+                                    directory, slot int) (int, error) {
+    n, offset := h.dirlocation(directory, slot)
+    page, err := h.batchpage(batch, n)
+    if err != nil {
+        return 0, err
+    }
+    return int(binary.BigEndian.Uint64(page[offset:])), nil
+}
+
+
+func (h *DiskHashTable[K, V]) setfirst(batch map[int][]byte,
+                                       directory, slot, first int) error {
+    n, offset := h.dirlocation(directory, slot)
+    page, err := h.batchpage(batch, n)
+    if err != nil {
+        return err
+    }
+    binary.BigEndian.PutUint64(page[offset:], uint64(first))
+    batch[n] = page
+    return nil
+}
+
+
+// Calls f with each page of a chain until it returns false.
+func (h *DiskHashTable[K, V]) chain(batch map[int][]byte,
+                                    first int,
+                                    f func(n int, p DiskHashTablePage) bool) error {
+    for n := first; n != 0; {
+        page, err := h.batchpage(batch, n)
+        if err != nil {
+            return err
+        }
+        p, err := decodepage(page)
+        if err != nil {
+            return err
+        }
+        if !f(n, p) {
+            return nil
+        }
+        n = p.next
+    }
+    return nil
+}
+
+
+func (h *DiskHashTable[K, V]) Get(k K) (V, bool, error) {
+    kb := h.kcodec.Encode(k)
+    first, err := h.first(nil, h.directory, h.hash(k, h.nslots))
+    if err != nil {
+        return *new(V), false, err
+    }
+    var vb []byte
+    found := false
+    err = h.chain(nil, first, func(_ int, p DiskHashTablePage) bool {
+                                  for _, e := range p.entries {
+                                      if bytes.Equal(e.k, kb) {
+                                          vb = e.v
+                                          found = true
+                                          return false
+                                      }
+                                  }
+                                  return true
+                              })
+    if err != nil || !found {
+        return *new(V), false, err
+    }
+    v, err := h.vcodec.Decode(vb)
+    return v, err == nil, err
+}
+
+
+func (h *DiskHashTable[K, V]) Keys() ([]K, error) {
+    keys := make([]K, 0, h.nentries)
+    for slot := 0; slot < h.nslots; slot++ {
+        first, err := h.first(nil, h.directory, slot)
+        if err != nil {
+            return nil, err
+        }
+        var kerr error
+        err = h.chain(nil, first, func(_ int, p DiskHashTablePage) bool {
+                                      for _, e := range p.entries {
+                                          k, err := h.kcodec.Decode(e.k)
+                                          if err != nil {
+                                              kerr = err
+                                              return false
+                                          }
+                                          keys = append(keys, k)
+                                      }
+                                      return true
+                                  })
+        if err != nil {
+            return nil, err
+        }
+        if kerr != nil {
+            return nil, kerr
+        }
+    }
+    return keys, nil
+}
+
+
+// Removes kb from the slot's chain in batch, reporting whether it was there.
+func (h *DiskHashTable[K, V]) remove(batch map[int][]byte,
+                                     directory, slot int,
+                                     kb []byte) (bool, error) {
+    first, err := h.first(batch, directory, slot)
+    if err != nil {
+        return false, err
+    }
+    found := false
+    err = h.chain(batch, first, func(n int, p DiskHashTablePage) bool {
+                                    for i, e := range p.entries {
+                                        if bytes.Equal(e.k, kb) {
+                                            p.entries = append(p.entries[:i],
+                                                               p.entries[i + 1:]...)
+                                            batch[n] = p.encode()
+                                            found = true
+                                            return false
+                                        }
+                                    }
+                                    return true
+                                })
+    return found, err
+}
+
+
+// Adds an entry to the first page of the slot's chain with room for it, or
+// to a new page at the end of the file.
+func (h *DiskHashTable[K, V]) add(batch map[int][]byte,
+                                  directory, slot int,
+                                  e DiskHashTableEntry) error {
+    first, err := h.first(batch, directory, slot)
+    if err != nil {
+        return err
+    }
+    added := false
+    err = h.chain(batch, first, func(n int, p DiskHashTablePage) bool {
+                                    if p.size() + 4 + len(e.k) + len(e.v) <= diskhashtablepagesize {
+                                        p.entries = append(p.entries, e)
+                                        batch[n] = p.encode()
+                                        added = true
+                                        return false
+                                    }
+                                    return true
+                                })
+    if err != nil || added {
+        return err
+    }
+    n := h.npages
+    h.npages++
+    batch[n] = DiskHashTablePage{ first, []DiskHashTableEntry{ e } }.encode()
+    return h.setfirst(batch, directory, slot, n)
+}
+
+
+func (h *DiskHashTable[K, V]) Set(k K, v V) error {
+    e := DiskHashTableEntry{ h.kcodec.Encode(k), h.vcodec.Encode(v) }
+    if 10 + 4 + len(e.k) + len(e.v) > diskhashtablepagesize {
+        return errors.New("DiskHashTable: entry too large for a page")
+    }
+    batch := make(map[int][]byte)
+    slot := h.hash(k, h.nslots)
+    npages := h.npages
+    found, err := h.remove(batch, h.directory, slot, e.k)
+    if err == nil {
+        err = h.add(batch, h.directory, slot, e)
+    }
+    if err != nil {
+        h.npages = npages
+        return err
+    }
+    // The header written by commit holds the new count, so it's changed
+    // first and put back if the commit fails.
+    nentries := h.nentries
+    if !found {
+        h.nentries++
+    }
+    if err := h.commit(batch); err != nil {
+        h.nentries, h.npages = nentries, npages
+        return err
+    }
+    if h.LoadFactor() > 0.7 {
+        return h.Resize(h.nslots * 2)
+    }
+    return nil
+}
+
+
+func (h *DiskHashTable[K, V]) Remove(k K) error {
+    batch := make(map[int][]byte)
+    found, err := h.remove(batch, h.directory, h.hash(k, h.nslots),
+                           h.kcodec.Encode(k))
+    if err != nil || !found {
+        return err
+    }
+    h.nentries--
+    if err := h.commit(batch); err != nil {
+        h.nentries++
+        return err
+    }
+    return nil
+}
+
+
+// Writes a new directory and chains after the end of the file, moving one
+// old chain at a time and writing the new pages out as they accumulate,
+// then commits the header pointing at them.
+func (h *DiskHashTable[K, V]) Resize(nslots int) error {
+    directory := h.npages
+    npages := h.npages
+    h.npages += diskhashtabledirpages(nslots)
+    batch := make(map[int][]byte)
+    for i := 0; i < diskhashtabledirpages(nslots); i++ {
+        batch[directory + i] = make([]byte, diskhashtablepagesize)
+    }
+    fail := func(err error) error {
+        h.npages = npages
+        return err
+    }
+    for slot := 0; slot < h.nslots; slot++ {
+        first, err := h.first(nil, h.directory, slot)
+        if err != nil {
+            return fail(err)
+        }
+        var entries []DiskHashTableEntry
+        err = h.chain(nil, first, func(_ int, p DiskHashTablePage) bool {
+                                      entries = append(entries, p.entries...)
+                                      return true
+                                  })
+        if err != nil {
+            return fail(err)
+        }
+        for _, e := range entries {
+            k, err := h.kcodec.Decode(e.k)
+            if err != nil {
+                return fail(err)
+            }
+            if err := h.add(batch, directory, h.hash(k, nslots), e); err != nil {
+                return fail(err)
+            }
+        }
+        if len(batch) >= diskhashtableresizebatch || slot == h.nslots - 1 {
+            if err := h.writepages(batch); err != nil {
+                return fail(err)
+            }
+        }
+    }
+    if err := h.f.Sync(); err != nil {
+        return fail(err)
+    }
+    olddirectory, oldnslots := h.directory, h.nslots
+    h.directory, h.nslots = directory, nslots
+    if err := h.commit(make(map[int][]byte)); err != nil {
+        h.directory, h.nslots = olddirectory, oldnslots
+        return fail(err)
+    }
+    return nil
+}
+
+
+// Writes out and forgets the pages in batch, without logging them.  This is
+// only safe for pages beyond the end of the table as the header records it,
+// which a crash before the header is next committed leaves unused.
+func (h *DiskHashTable[K, V]) writepages(batch map[int][]byte) error {
+    for n, page := range batch {
+        if _, err := h.f.WriteAt(page, int64(n) * diskhashtablepagesize); err != nil {
+            return err
+        }
+        delete(batch, n)
+    }
+    return nil
+}
+
+
+// Logs the batch and the header, then writes them in place.
+func (h *DiskHashTable[K, V]) commit(batch map[int][]byte) error {
+    batch[0] = h.header()
+    if err := h.log(batch); err != nil {
+        return err
+    }
+    return h.apply(batch)
+}
+
+
+// The log is each page number and page, then a trailer of the magic number,
+// the page count and a CRC-32 of everything before it.
+func (h *DiskHashTable[K, V]) log(batch map[int][]byte) error {
+    var b bytes.Buffer
+    for n, page := range batch {
+        binary.Write(&b, binary.BigEndian, uint64(n))
+        b.Write(page)
+    }
+    binary.Write(&b, binary.BigEndian, uint32(diskhashtablewalmagic))
+    binary.Write(&b, binary.BigEndian, uint32(len(batch)))
+    binary.Write(&b, binary.BigEndian, crc32.ChecksumIEEE(b.Bytes()))
+    if err := h.wal.Truncate(0); err != nil {
+        return err
+    }
+    if _, err := h.wal.WriteAt(b.Bytes(), 0); err != nil {
+        return err
+    }
+    return h.wal.Sync()
+}
+
+
+func (h *DiskHashTable[K, V]) apply(batch map[int][]byte) error {
+    for n, page := range batch {
+        if _, err := h.f.WriteAt(page, int64(n) * diskhashtablepagesize); err != nil {
+            return err
+        }
+    }
+    if err := h.f.Sync(); err != nil {
+        return err
+    }
+    if err := h.wal.Truncate(0); err != nil {
+        return err
+    }
+    return h.wal.Sync()
+}
+
+
+// Replays a complete log left by a crash and discards an incomplete one.
+func (h *DiskHashTable[K, V]) recover() error {
+    if _, err := h.wal.Seek(0, io.SeekStart); err != nil {
+        return err
+    }
+    b, err := io.ReadAll(h.wal)
+    if err != nil {
+        return err
+    }
+    const recordsize = 8 + diskhashtablepagesize
+    if len(b) < 12 || (len(b) - 12) % recordsize != 0 {
+        return h.wal.Truncate(0)
+    }
+    trailer := b[len(b) - 12:]
+    if binary.BigEndian.Uint32(trailer) != diskhashtablewalmagic ||
+       int(binary.BigEndian.Uint32(trailer[4:])) != (len(b) - 12) / recordsize ||
+       binary.BigEndian.Uint32(trailer[8:]) != crc32.ChecksumIEEE(b[:len(b) - 4]) {
+        return h.wal.Truncate(0)
+    }
+    batch := make(map[int][]byte)
+    for i := 0; i < len(b) - 12; i += recordsize {
+        batch[int(binary.BigEndian.Uint64(b[i:]))] = b[i + 8:i + recordsize]
+    }
+    return h.apply(batch)
+}
+
+
+func hashsumchars(s string, m int) int {
+    sum := 0
+    for _, e := range s {
+        sum += int(e)
+    }
+    return sum % m
+}
+
+
+func main() {
+    elements := []string{ "hydrogen",
+                          "helium",
+                          "lithium",
+                          "beryllium",
+                          "boron",
+                          "carbon",
+                          "nitrogen",
+                          "oxygen",
+                          "fluorine",
+                          "neon" }
+    dir, err := os.MkdirTemp("", "diskhashtable")
+    if err != nil {
+        fmt.Println(err)
+        os.Exit(1)
+    }
+    // Not deferred, since os.Exit doesn't run deferred calls.
+    check := func(err error) {
+        if err != nil {
+            fmt.Println(err)
+            os.RemoveAll(dir)
+            os.Exit(1)
+        }
+    }
+    path := filepath.Join(dir, "elements")
+
+
+    h, err := DiskHashTableOpen[string, int](path, 1, hashsumchars,
+                                             StringCodec{}, IntCodec{})
+    check(err)
+    for _, e := range elements {
+        check(h.Set(e, len(e)))
+    }
+    check(h.Remove("boron"))
+    fmt.Printf("%v %v %v\n", h.Len(), h.nslots, h.npages)
+    // => 9 16 18
+    check(h.Close())
+
+
+    h, err = DiskHashTableOpen[string, int](path, 1, hashsumchars,
+                                            StringCodec{}, IntCodec{})
+    check(err)
+    v, ok, err := h.Get("oxygen")
+    fmt.Printf("%v %v %v\n", v, ok, err)
+    // => 6 true <nil>
+    _, ok, err = h.Get("boron")
+    fmt.Printf("%v %v\n", ok, err)
+    // => false <nil>
+    keys, err := h.Keys()
+    fmt.Printf("%v %v\n", len(keys), err)
+    // => 9 <nil>
+
+
+    // A crash after the log is written but before the pages are.
+    batch := make(map[int][]byte)
+    slot := hashsumchars("oxygen", h.nslots)
+    h.remove(batch, h.directory, slot, []byte("oxygen"))
+    h.add(batch, h.directory, slot,
+          DiskHashTableEntry{ []byte("oxygen"), IntCodec{}.Encode(8) })
+    batch[0] = h.header()
+    check(h.log(batch))
+    check(h.Close())
+
+    h, err = DiskHashTableOpen[string, int](path, 1, hashsumchars,
+                                            StringCodec{}, IntCodec{})
+    check(err)
+    v, ok, err = h.Get("oxygen")
+    fmt.Printf("%v %v %v\n", v, ok, err)
+    // => 8 true <nil>
+
+
+    for i := 0; i < 1000; i++ {
+        check(h.Set(strconv.Itoa(i), i))
+    }
+    v, ok, err = h.Get("999")
+    fmt.Printf("%v %v %v %v\n", h.Len(), v, ok, err)
+    // => 1009 999 true <nil>
+
+
+    // A failed write leaves the count as it was on disk.
+    check(h.wal.Close())
+    err = h.Set("xenon", 5)
+    fmt.Printf("%v %v\n", err != nil, h.Len())
+    // => true 1009
+    err = h.Remove("oxygen")
+    fmt.Printf("%v %v\n", err != nil, h.Len())
+    // => true 1009
+    check(h.f.Close())
+
+    h, err = DiskHashTableOpen[string, int](path, 1, hashsumchars,
+                                            StringCodec{}, IntCodec{})
+    check(err)
+    _, ok, err = h.Get("xenon")
+    fmt.Printf("%v %v %v\n", h.Len(), ok, err)
+    // => 1009 false <nil>
+    check(h.Close())
+
+
+    os.RemoveAll(dir)
+    os.Exit(0)
+}
@@ -0,0 +1,553 @@
+package main
+
+
+// host$ go build externalsort.go
+// host$ ./externalsort
+// host$ ./externalsort -memory 1048576 -fanin 8 file...
+
+
+import "bufio"
+import "bytes"
+import "container/heap"
+import "encoding/binary"
+import "errors"
+import "flag"
+import "fmt"
+import "io"
+import "iter"
+import "math/rand"
+import "os"
+import "slices"
+import "strings"
+
+
+// Sorts more records than fit in memory.  Records are read in chunks of up
+// to the memory budget, each chunk is sorted with MergeSort and written to
+// a temporary file as a sorted run, then the runs are merged, at most fanin
+// at a time, keeping one record per run in a heap.  If there are more than
+// fanin runs, groups of them are merged into longer runs first, so that no
+// more than fanin + 1 files are open at once: fanin runs being read, and in
+// those earlier passes, the run being written.  Input that fits in one chunk
+// never touches the disk.
+//
+// The sort is stable: chunks are sorted stably, and when records from
+// different runs are equal the one from the earlier run goes first.
+
+
+type Ordered interface {
+    ~int | ~int8 | ~int16 | ~int32 | ~int64 |
+    ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 |
+    ~uintptr | ~float32 | ~float64 | ~string
+}
+
+
+func Compare[A Ordered](x, y A) int {
+    booltoint := func (b bool) int {
+        if b {
+            return 1
+        } else {
+            return 0
+        }
+    }
+
+    return booltoint(x > y) - booltoint(x < y)
+}
+
+
+const quicksortinsertion = 12
+
+
+func InsertionSort[A any](compare func (x, y A) int, l []A) []A {
+    for i := 1; i < len(l); i++ {
+        for j := i; j > 0 && compare(l[j], l[j - 1]) < 0; j-- {
+            l[j], l[j - 1] = l[j - 1], l[j]
+        }
+    }
+    return l
+}
+
+
+// Stable, top-down, with one buffer half the length of l.
+func MergeSort[A any](compare func (x, y A) int, l []A) []A {
+    mergesort(compare, l, make([]A, 0, len(l) / 2 + 1))
+    return l
+}
+
+
+func mergesort[A any](compare func (x, y A) int, l []A, buf []A) {
+    if len(l) <= quicksortinsertion {
+        InsertionSort(compare, l)
+        return
+    }
+    mid := len(l) / 2
+    mergesort(compare, l[:mid], buf)
+    mergesort(compare, l[mid:], buf)
+    if compare(l[mid - 1], l[mid]) > 0 {
+        merge(compare, l, mid, buf)
+    }
+}
+
+
+// Merges the sorted l[:mid] and l[mid:], copying the first into buf.  Ties
+// go to the first, which keeps the merge stable.
+func merge[A any](compare func (x, y A) int, l []A, mid int, buf []A) {
+    buf = append(buf[:0], l[:mid]...)
+    i, j, k := 0, mid, 0
+    for i < len(buf) && j < len(l) {
+        if compare(l[j], buf[i]) < 0 {
+            l[k] = l[j]
+            j++
+        } else {
+            l[k] = buf[i]
+            i++
+        }
+        k++
+    }
+    copy(l[k:], buf[i:])
+}
+
+
+// Reads and writes one record at a time, for both the input and output and
+// the temporary runs.  Read returns io.EOF, and no record, at the end of
+// the input.  Size is roughly how much memory a record takes, which is what
+// the memory budget is measured in.
+type ExternalSortCodec[A any] interface {
+    Read(r *bufio.Reader) (A, error)
+    Write(w *bufio.Writer, a A) error
+    Size(a A) int
+}
+
+
+// Newline-terminated strings.  The last line needn't be terminated, but is
+// written with a newline like the others.
+type LineCodec struct{}
+
+
+func (LineCodec) Read(r *bufio.Reader) (string, error) {
+    s, err := r.ReadString('\n')
+    if err == io.EOF && len(s) > 0 {
+        return s, nil
+    }
+    if err != nil {
+        return "", err
+    }
+    return s[:len(s) - 1], nil
+}
+
+
+func (LineCodec) Write(w *bufio.Writer, s string) error {
+    if _, err := w.WriteString(s); err != nil {
+        return err
+    }
+    return w.WriteByte('\n')
+}
+
+
+// The string header as well as its bytes.
+func (LineCodec) Size(s string) int {
+    return len(s) + 16
+}
+
+
+// Ints as binary varints.
+type VarintCodec struct{}
+
+
+func (VarintCodec) Read(r *bufio.Reader) (int, error) {
+    i, err := binary.ReadVarint(r)
+    return int(i), err
+}
+
+
+func (VarintCodec) Write(w *bufio.Writer, i int) error {
+    _, err := w.Write(binary.AppendVarint(nil, int64(i)))
+    return err
+}
+
+
+func (VarintCodec) Size(i int) int {
+    return 8
+}
+
+
+// memory is the budget for the records of one chunk, as measured by the
+// codec's Size; MergeSort needs half as much again for its buffer.  fanin
+// is the most runs merged at once, and dir is where the runs go, the
+// default temporary directory if it's empty.
+type ExternalSortOptions struct {
+    memory int
+    fanin int
+    dir string
+}
+
+
+type ExternalSortOption func(o *ExternalSortOptions)
+
+
+func ExternalSortWithMemory(memory int) ExternalSortOption {
+    return func(o *ExternalSortOptions) {
+        o.memory = memory
+    }
+}
+
+
+func ExternalSortWithFanIn(fanin int) ExternalSortOption {
+    return func(o *ExternalSortOptions) {
+        o.fanin = fanin
+    }
+}
+
+
+func ExternalSortWithTempDir(dir string) ExternalSortOption {
+    return func(o *ExternalSortOptions) {
+        o.dir = dir
+    }
+}
+
+
+// Sorts the records read from r with codec, writing them to w.  The
+// temporary files are removed before returning, whether or not it
+// succeeds.
+func ExternalSort[A any](compare func (x, y A) int,
+                         r io.Reader,
+                         w io.Writer,
+                         codec ExternalSortCodec[A],
+                         options ...ExternalSortOption) error {
+    o := ExternalSortOptions{ 64 << 20, 16, "" }
+    for _, option := range options {
+        option(&o)
+    }
+    if o.memory <= 0 || o.fanin < 2 {
+        return errors.New("ExternalSort: memory must be positive and fanin at least 2")
+    }
+
+    var temps []string
+    defer func() {
+        for _, temp := range temps {
+            os.Remove(temp)
+        }
+    }()
+    create := func() (*os.File, error) {
+        f, err := os.CreateTemp(o.dir, "externalsort-")
+        if err == nil {
+            temps = append(temps, f.Name())
+        }
+        return f, err
+    }
+
+    br := bufio.NewReader(r)
+    bw := bufio.NewWriter(w)
+    runs := make([]string, 0)
+    chunk := make([]A, 0)
+    for eof := false; !eof; {
+        chunk = chunk[:0]
+        for size := 0; size < o.memory; {
+            a, err := codec.Read(br)
+            if err == io.EOF {
+                eof = true
+                break
+            }
+            if err != nil {
+                return err
+            }
+            chunk = append(chunk, a)
+            size += codec.Size(a)
+        }
+        MergeSort(compare, chunk)
+        if eof && len(runs) == 0 {
+            for _, a := range chunk {
+                if err := codec.Write(bw, a); err != nil {
+                    return err
+                }
+            }
+            return bw.Flush()
+        }
+        if len(chunk) == 0 {
+            continue
+        }
+        f, err := create()
+        if err != nil {
+            return err
+        }
+        err = externalsortwrite(f, codec, slices.Values(chunk))
+        if err != nil {
+            return err
+        }
+        runs = append(runs, f.Name())
+    }
+    chunk = nil
+
+    for len(runs) > o.fanin {
+        next := make([]string, 0, (len(runs) + o.fanin - 1) / o.fanin)
+        for i := 0; i < len(runs); i += o.fanin {
+            group := runs[i:min(i + o.fanin, len(runs))]
+            f, err := create()
+            if err != nil {
+                return err
+            }
+            fw := bufio.NewWriter(f)
+            err = externalsortmerge(compare, codec, group, fw)
+            if err == nil {
+                err = fw.Flush()
+            }
+            if err1 := f.Close(); err == nil {
+                err = err1
+            }
+            if err != nil {
+                return err
+            }
+            for _, run := range group {
+                os.Remove(run)
+            }
+            next = append(next, f.Name())
+        }
+        runs = next
+    }
+    if err := externalsortmerge(compare, codec, runs, bw); err != nil {
+        return err
+    }
+    return bw.Flush()
+}
+
+
+// Writes the records to f and closes it.
+func externalsortwrite[A any](f *os.File,
+                              codec ExternalSortCodec[A],
+                              records iter.Seq[A]) error {
+    fw := bufio.NewWriter(f)
+    for a := range records {
+        if err := codec.Write(fw, a); err != nil {
+            f.Close()
+            return err
+        }
+    }
+    err := fw.Flush()
+    if err1 := f.Close(); err == nil {
+        err = err1
+    }
+    return err
+}
+
+
+// The next record of each run being merged.
+type ExternalSortCursor[A any] struct {
+    a A
+    run int
+    r *bufio.Reader
+}
+
+
+// A heap of cursors, least record first, ties going to the earlier run.
+type ExternalSortHeap[A any] struct {
+    cursors []*ExternalSortCursor[A]
+    compare func (x, y A) int
+}
+
+
+func (h *ExternalSortHeap[A]) Len() int {
+    return len(h.cursors)
+}
+
+
+func (h *ExternalSortHeap[A]) Less(i, j int) bool {
+    if c := h.compare(h.cursors[i].a, h.cursors[j].a); c != 0 {
+        return c < 0
+    } else {
+        return h.cursors[i].run < h.cursors[j].run
+    }
+}
+
+
+func (h *ExternalSortHeap[A]) Swap(i, j int) {
+    h.cursors[i], h.cursors[j] = h.cursors[j], h.cursors[i]
+}
+
+
+func (h *ExternalSortHeap[A]) Push(x any) {
+    h.cursors = append(h.cursors, x.(*ExternalSortCursor[A]))
+}
+
+
+func (h *ExternalSortHeap[A]) Pop() any {
+    c := h.cursors[len(h.cursors) - 1]
+    h.cursors = h.cursors[:len(h.cursors) - 1]
+    return c
+}
+
+
+// Merges the runs, in order, to w.
+func externalsortmerge[A any](compare func (x, y A) int,
+                              codec ExternalSortCodec[A],
+                              runs []string,
+                              w *bufio.Writer) error {
+    h := &ExternalSortHeap[A]{ make([]*ExternalSortCursor[A], 0, len(runs)), compare }
+    for i, run := range runs {
+        f, err := os.Open(run)
+        if err != nil {
+            return err
+        }
+        defer f.Close()
+        r := bufio.NewReader(f)
+        a, err := codec.Read(r)
+        if err == io.EOF {
+            continue
+        }
+        if err != nil {
+            return err
+        }
+        h.cursors = append(h.cursors, &ExternalSortCursor[A]{ a, i, r })
+    }
+    heap.Init(h)
+    for h.Len() > 0 {
+        c := h.cursors[0]
+        if err := codec.Write(w, c.a); err != nil {
+            return err
+        }
+        a, err := codec.Read(c.r)
+        if err == io.EOF {
+            heap.Pop(h)
+            continue
+        }
+        if err != nil {
+            return err
+        }
+        c.a = a
+        heap.Fix(h, 0)
+    }
+    return nil
+}
+
+
+// So that the last line of one file isn't joined to the first of the next.
+func endsinnewline(f *os.File) (bool, error) {
+    info, err := f.Stat()
+    if err != nil || info.Size() == 0 {
+        return true, err
+    }
+    b := make([]byte, 1)
+    if _, err := f.ReadAt(b, info.Size() - 1); err != nil {
+        return false, err
+    }
+    return b[0] == '\n', nil
+}
+
+
+// Sorts the lines of all the files together to standard output.
+func sortfiles(filenames []string, options ...ExternalSortOption) error {
+    readers := make([]io.Reader, 0, len(filenames))
+    for _, filename := range filenames {
+        f, err := os.Open(filename)
+        if err != nil {
+            return err
+        }
+        defer f.Close()
+        readers = append(readers, f)
+        newline, err := endsinnewline(f)
+        if err != nil {
+            return err
+        }
+        if !newline {
+            readers = append(readers, strings.NewReader("\n"))
+        }
+    }
+    return ExternalSort(Compare[string],
+                        io.MultiReader(readers...),
+                        os.Stdout,
+                        LineCodec{},
+                        options...)
+}
+
+
+func main() {
+    memory := flag.Int("memory", 64 << 20, "bytes of records to sort in memory at once")
+    fanin := flag.Int("fanin", 16, "most runs to merge at once")
+    dir := flag.String("tmpdir", "", "directory for the sorted runs")
+    flag.Parse()
+    if flag.NArg() > 0 {
+        err := sortfiles(flag.Args(),
+                         ExternalSortWithMemory(*memory),
+                         ExternalSortWithFanIn(*fanin),
+                         ExternalSortWithTempDir(*dir))
+        if err != nil {
+            fmt.Fprintln(os.Stderr, err)
+            os.Exit(1)
+        }
+        os.Exit(0)
+    }
+
+
+    var out bytes.Buffer
+    err := ExternalSort(Compare[string],
+                        strings.NewReader("oxygen\nhydrogen\nhelium\ncarbon\nnitrogen"),
+                        &out,
+                        LineCodec{})
+    fmt.Printf("%q %v\n", out.String(), err)
+    // => "carbon\nhelium\nhydrogen\nnitrogen\noxygen\n" <nil>
+
+
+    // 100000 ints at 8 bytes each, in runs of 8192, merged 4 at a time:
+    // 13 runs, then 4, then the output.
+    l := make([]int, 100000)
+    for i := range l {
+        l[i] = rand.Intn(2000000) - 1000000
+    }
+    var in bytes.Buffer
+    inw := bufio.NewWriter(&in)
+    for _, e := range l {
+        VarintCodec{}.Write(inw, e)
+    }
+    inw.Flush()
+    out.Reset()
+    err = ExternalSort(Compare[int], &in, &out, VarintCodec{},
+                       ExternalSortWithMemory(64 << 10),
+                       ExternalSortWithFanIn(4))
+    got := make([]int, 0, len(l))
+    outr := bufio.NewReader(&out)
+    for {
+        e, err := VarintCodec{}.Read(outr)
+        if err != nil {
+            break
+        }
+        got = append(got, e)
+    }
+    slices.Sort(l)
+    fmt.Printf("%v %v\n", slices.Equal(got, l), err)
+    // => true <nil>
+
+
+    // Equal keys keep their input order across runs.
+    type Record struct {
+        key int
+        index int
+    }
+    var records bytes.Buffer
+    for i := 0; i < 1000; i++ {
+        fmt.Fprintf(&records, "%v %v\n", i % 7, i)
+    }
+    out.Reset()
+    err = ExternalSort(func(x, y string) int {
+                           return Compare(x[:1], y[:1])
+                       },
+                       &records, &out, LineCodec{},
+                       ExternalSortWithMemory(1000),
+                       ExternalSortWithFanIn(3))
+    sorted := make([]Record, 0, 1000)
+    for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+        var r Record
+        fmt.Sscanf(line, "%d %d", &r.key, &r.index)
+        sorted = append(sorted, r)
+    }
+    fmt.Printf("%v %v %v\n",
+               len(sorted),
+               slices.IsSortedFunc(sorted, func(x, y Record) int {
+                                               if x.key != y.key {
+                                                   return Compare(x.key, y.key)
+                                               }
+                                               return Compare(x.index, y.index)
+                                           }),
+               err)
+    // => 1000 true <nil>
+
+
+    os.Exit(0)
+}
@@ -0,0 +1,522 @@
+package main
+
+
+// host$ go build hashset1.go
+// host$ ./hashset1
+
+
+import "fmt"
+import "iter"
+import "math"
+import "os"
+
+
+type HashTableEntry[K any, V comparable] struct {
+    k K
+    v V
+}
+
+
+// The table grows by growth when the load factor rises above grow, and
+// shrinks by the same factor when it falls below shrink, but never below
+// minslots.  shrink * growth must be less than grow so that a table that has
+// just shrunk can't immediately grow again, and vice versa.
+type HashTablePolicy struct {
+    grow float32
+    shrink float32
+    growth float32
+    minslots int
+    expected int
+    incremental int
+}
+
+
+type HashTableOption func(p *HashTablePolicy)
+
+
+func HashTableWithThresholds(grow, shrink float32) HashTableOption {
+    return func(p *HashTablePolicy) {
+        p.grow = grow
+        p.shrink = shrink
+    }
+}
+
+
+func HashTableWithGrowthFactor(growth float32) HashTableOption {
+    return func(p *HashTablePolicy) {
+        p.growth = growth
+    }
+}
+
+
+func HashTableWithMinSlots(minslots int) HashTableOption {
+    return func(p *HashTablePolicy) {
+        p.minslots = minslots
+    }
+}
+
+
+// Instead of rehashing every entry at once, a resize keeps the old slots
+// alongside the new ones and each Set or Remove moves the key's own old
+// bucket plus up to nbuckets more, bounding the cost of any one call.
+// nbuckets needs to be at least 1 / ((growth - 1) * grow), 2 by default, for
+// each migration to finish before the next resize is due.
+func HashTableWithIncrementalResize(nbuckets int) HashTableOption {
+    return func(p *HashTablePolicy) {
+        p.incremental = nbuckets
+    }
+}
+
+
+// Pre-sizes the table so that n entries can be set without a resize.
+func HashTableWithExpected(n int) HashTableOption {
+    return func(p *HashTablePolicy) {
+        p.expected = n
+    }
+}
+
+
+type HashTable[K any, V comparable] struct {
+    nentries int
+    nslots int
+    slots [][]HashTableEntry[K, V]
+    hash func(k K, m int) int
+    equal func(x, y K) bool
+    policy HashTablePolicy
+    oldslots [][]HashTableEntry[K, V]
+    migrated int
+}
+
+
+func hashtablenew[K any, V comparable](nslots int,
+                                       hash func(k K, m int) int,
+                                       equal func(x, y K) bool,
+                                       policy HashTablePolicy) *HashTable[K, V] {
+    h := new(HashTable[K, V])
+    h.nentries = 0
+    h.nslots = nslots
+    h.slots = make([][]HashTableEntry[K, V], nslots)
+    for i := range h.slots {
+        h.slots[i] = make([]HashTableEntry[K, V], 0)
+    }
+    h.hash = hash
+    h.equal = equal
+    h.policy = policy
+    return h
+}
+
+
+func HashTableNew[K, V comparable](nslots int,
+                                   hash func(k K, m int) int,
+                                   options ...HashTableOption) *HashTable[K, V] {
+    return HashTableNewFunc[K, V](nslots,
+                                  hash,
+                                  func(x, y K) bool { return x == y },
+                                  options...)
+}
+
+
+// For keys that aren't comparable, or that need comparing other than with
+// ==, such as case-insensitive strings.  Keys that are equal must hash to
+// the same slot.
+func HashTableNewFunc[K any, V comparable](nslots int,
+                                           hash func(k K, m int) int,
+                                           equal func(x, y K) bool,
+                                           options ...HashTableOption) *HashTable[K, V] {
+    policy := HashTablePolicy{ 0.7, 0.3, 2, nslots, 0, 0 }
+    for _, option := range options {
+        option(&policy)
+    }
+    if policy.minslots < 1 ||
+       policy.growth <= 1 ||
+       policy.grow <= 0 ||
+       policy.shrink < 0 ||
+       policy.incremental < 0 ||
+       policy.shrink * policy.growth >= policy.grow {
+        panic(fmt.Sprintf("HashTableNew: invalid policy %+v", policy))
+    }
+    nslots = max(nslots, policy.minslots, policy.slotsfor(policy.expected))
+    return hashtablenew[K, V](nslots, hash, equal, policy)
+}
+
+
+type Hasher[K any] interface {
+    Hash(k K, m int) int
+    Equal(x, y K) bool
+}
+
+
+func HashTableNewHasher[K any, V comparable](nslots int,
+                                             hasher Hasher[K],
+                                             options ...HashTableOption) *HashTable[K, V] {
+    return HashTableNewFunc[K, V](nslots, hasher.Hash, hasher.Equal, options...)
+}
+
+
+// The number of slots needed to hold n entries without growing.
+func (p HashTablePolicy) slotsfor(n int) int {
+    return max(p.minslots, int(math.Ceil(float64(float32(n) / p.grow))))
+}
+
+
+func HashTableGet[K any, V comparable](h *HashTable[K, V], k K) (V, bool) {
+    if h.oldslots != nil {
+        for _, e := range h.oldslots[h.hash(k, len(h.oldslots))] {
+            if h.equal(e.k, k) {
+                return e.v, true
+            }
+        }
+    }
+    slot := h.hash(k, h.nslots)
+    for _, e := range h.slots[slot] {
+        if h.equal(e.k, k) {
+            return e.v, true
+        }
+    }
+    return *new(V), false
+}
+
+
+func HashTableKeys[K any, V comparable](h *HashTable[K, V]) []K {
+    keys := make([]K, 0, h.nentries)
+    slots := hashtableslots(h)
+    for i, _ := range slots {
+        for _, e := range slots[i] {
+            keys = append(keys, e.k)
+        }
+    }
+    return keys
+}
+
+
+// All buckets, including those still waiting to be migrated.
+func hashtableslots[K any, V comparable](h *HashTable[K, V]) [][]HashTableEntry[K, V] {
+    if h.oldslots == nil {
+        return h.slots
+    }
+    return append(h.oldslots[:len(h.oldslots):len(h.oldslots)], h.slots...)
+}
+
+
+func HashTableLoadFactor[K any, V comparable](h *HashTable[K, V]) float32 {
+    return float32(h.nentries) / float32(h.nslots)
+}
+
+
+func remove[T any](v []T, i int) []T {
+    v[i] = v[len(v) - 1]
+    v = v[:len(v) - 1]
+    return v
+}
+
+
+func hashtablemigratebucket[K any, V comparable](h *HashTable[K, V], slot int) {
+    for _, e := range h.oldslots[slot] {
+        slot2 := h.hash(e.k, h.nslots)
+        h.slots[slot2] = append(h.slots[slot2], e)
+    }
+    h.oldslots[slot] = nil
+}
+
+
+// Moves k's old bucket, so that it only needs looking for in the new slots,
+// then moves up to policy.incremental more.
+func hashtablemigrate[K any, V comparable](h *HashTable[K, V], k K) {
+    if h.oldslots == nil {
+        return
+    }
+    hashtablemigratebucket(h, h.hash(k, len(h.oldslots)))
+    for n := 0;
+        n < h.policy.incremental && h.migrated < len(h.oldslots);
+        n++ {
+        hashtablemigratebucket(h, h.migrated)
+        h.migrated++
+    }
+    if h.migrated == len(h.oldslots) {
+        h.oldslots = nil
+        h.migrated = 0
+    }
+}
+
+
+// Resizes all at once, or starts an incremental resize if the policy asks
+// for one.  A resize already under way is left to finish first.
+func hashtableresize[K any, V comparable](h *HashTable[K, V],
+                                          nslots int) *HashTable[K, V] {
+    if h.policy.incremental == 0 {
+        return HashTableResize(h, nslots)
+    }
+    if h.oldslots != nil {
+        return h
+    }
+    h.oldslots = h.slots
+    h.migrated = 0
+    h.nslots = nslots
+    h.slots = make([][]HashTableEntry[K, V], nslots)
+    for i := range h.slots {
+        h.slots[i] = make([]HashTableEntry[K, V], 0)
+    }
+    return h
+}
+
+
+func hashtableremove[K any, V comparable](h *HashTable[K, V],
+                                          k K) *HashTable[K, V] {
+    hashtablemigrate(h, k)
+    slot := h.hash(k, h.nslots)
+    for i, e := range h.slots[slot] {
+        if h.equal(e.k, k) {
+            h.slots[slot] = remove(h.slots[slot], i)
+            h.nentries--
+            return h
+        }
+    }
+    return h
+}
+
+
+func HashTableRemove[K any, V comparable](h *HashTable[K, V],
+                                          k K) *HashTable[K, V] {
+    h = hashtableremove(h, k)
+    if HashTableLoadFactor(h) < h.policy.shrink &&
+       h.nslots > h.policy.minslots {
+        nslots := int(float32(h.nslots) / h.policy.growth)
+        h = hashtableresize(h, max(nslots, h.policy.minslots))
+    }
+    return h
+}
+
+
+// Shrinks the table to the fewest slots that hold its entries without
+// growing.
+func HashTableShrink[K any, V comparable](h *HashTable[K, V]) *HashTable[K, V] {
+    nslots := h.policy.slotsfor(h.nentries)
+    if nslots < h.nslots {
+        h = HashTableResize(h, nslots)
+    }
+    return h
+}
+
+
+// Grows the table so that it holds n entries without a further resize.
+func HashTableReserve[K any, V comparable](h *HashTable[K, V],
+                                           n int) *HashTable[K, V] {
+    nslots := h.policy.slotsfor(n)
+    if nslots > h.nslots {
+        h = HashTableResize(h, nslots)
+    }
+    return h
+}
+
+
+func HashTableResize[K any, V comparable](h *HashTable[K, V],
+                                          nslots int) *HashTable[K, V] {
+    h2 := hashtablenew[K, V](nslots, h.hash, h.equal, h.policy)
+    slots := hashtableslots(h)
+    for i, _ := range slots {
+        for _, e := range slots[i] {
+            h2 = hashtableset(h2, e.k, e.v)
+        }
+    }
+    return h2
+}
+
+
+func hashtableset[K any, V comparable](h *HashTable[K, V],
+                                       k K, v V) *HashTable[K, V] {
+    hashtablemigrate(h, k)
+    slot := h.hash(k, h.nslots)
+    for i, e := range h.slots[slot] {
+        if h.equal(e.k, k) {
+            h.slots[slot][i].v = v
+            return h
+        }
+    }
+    h.slots[slot] = append(h.slots[slot], HashTableEntry[K, V]{ k, v })
+    h.nentries++
+    return h
+}
+
+
+func HashTableSet[K any, V comparable](h *HashTable[K, V],
+                                       k K, v V) *HashTable[K, V] {
+    h = hashtableset(h, k, v)
+    if HashTableLoadFactor(h) > h.policy.grow {
+        nslots := int(math.Ceil(float64(float32(h.nslots) * h.policy.growth)))
+        h = hashtableresize(h, max(nslots, h.nslots + 1))
+    }
+    return h
+}
+
+
+// A mutable set of keys, stored as the keys of a HashTable from
+// hashtable1.go with empty values.
+type HashSet[K comparable] struct {
+    h *HashTable[K, struct{}]
+}
+
+
+func HashSetNew[K comparable](nslots int,
+                              hash func(k K, m int) int) *HashSet[K] {
+    s := new(HashSet[K])
+    s.h = HashTableNew[K, struct{}](nslots, hash)
+    return s
+}
+
+
+func HashSetOf[K comparable](hash func(k K, m int) int, ks ...K) *HashSet[K] {
+    s := HashSetNew(1, hash)
+    for _, k := range ks {
+        HashSetAdd(s, k)
+    }
+    return s
+}
+
+
+func (s *HashSet[K]) String() string {
+    return fmt.Sprintf("%v", HashTableKeys(s.h))
+}
+
+
+func HashSetAdd[K comparable](s *HashSet[K], k K) *HashSet[K] {
+    s.h = HashTableSet(s.h, k, struct{}{})
+    return s
+}
+
+
+func HashSetRemove[K comparable](s *HashSet[K], k K) *HashSet[K] {
+    s.h = HashTableRemove(s.h, k)
+    return s
+}
+
+
+func HashSetContains[K comparable](s *HashSet[K], k K) bool {
+    _, ok := HashTableGet(s.h, k)
+    return ok
+}
+
+
+func HashSetLen[K comparable](s *HashSet[K]) int {
+    return s.h.nentries
+}
+
+
+func HashSetAll[K comparable](s *HashSet[K]) iter.Seq[K] {
+    return func(yield func(k K) bool) {
+        for i, _ := range s.h.slots {
+            for _, e := range s.h.slots[i] {
+                if !yield(e.k) {
+                    return
+                }
+            }
+        }
+    }
+}
+
+
+// The operations below leave their arguments unchanged and return a new
+// set.
+
+
+func HashSetFilter[K comparable](s *HashSet[K], f func(k K) bool) *HashSet[K] {
+    s2 := HashSetNew(1, s.h.hash)
+    for k := range HashSetAll(s) {
+        if f(k) {
+            HashSetAdd(s2, k)
+        }
+    }
+    return s2
+}
+
+
+func HashSetUnion[K comparable](a, b *HashSet[K]) *HashSet[K] {
+    s := HashSetFilter(a, func(k K) bool { return true })
+    for k := range HashSetAll(b) {
+        HashSetAdd(s, k)
+    }
+    return s
+}
+
+
+func HashSetIntersection[K comparable](a, b *HashSet[K]) *HashSet[K] {
+    return HashSetFilter(a, func(k K) bool { return HashSetContains(b, k) })
+}
+
+
+func HashSetDifference[K comparable](a, b *HashSet[K]) *HashSet[K] {
+    return HashSetFilter(a, func(k K) bool { return !HashSetContains(b, k) })
+}
+
+
+func HashSetSymmetricDifference[K comparable](a, b *HashSet[K]) *HashSet[K] {
+    s := HashSetDifference(a, b)
+    for k := range HashSetAll(b) {
+        if !HashSetContains(a, k) {
+            HashSetAdd(s, k)
+        }
+    }
+    return s
+}
+
+
+func HashSetIsSubset[K comparable](a, b *HashSet[K]) bool {
+    if HashSetLen(a) > HashSetLen(b) {
+        return false
+    }
+    for k := range HashSetAll(a) {
+        if !HashSetContains(b, k) {
+            return false
+        }
+    }
+    return true
+}
+
+
+func hashsumchars(s string, m int) int {
+    sum := 0
+    for _, e := range s {
+        sum += int(e)
+    }
+    return sum % m
+}
+
+
+func main() {
+    metals := HashSetOf(hashsumchars,
+                        "lithium", "beryllium", "sodium", "magnesium",
+                        "aluminium", "potassium", "calcium")
+    period3 := HashSetOf(hashsumchars,
+                         "sodium", "magnesium", "aluminium", "silicon",
+                         "phosphorus", "sulfur", "chlorine", "argon")
+    fmt.Printf("%v %v\n", HashSetLen(metals), HashSetLen(period3))
+    // => 7 8
+
+    fmt.Printf("%v %v\n",
+               HashSetContains(metals, "sodium"),
+               HashSetContains(metals, "argon"))
+    // => true false
+
+    fmt.Printf("%v\n", HashSetLen(HashSetUnion(metals, period3)))
+    // => 12
+    fmt.Printf("%v\n", HashSetIntersection(metals, period3))
+    // => [sodium aluminium magnesium]
+    fmt.Printf("%v\n", HashSetLen(HashSetDifference(metals, period3)))
+    // => 4
+    fmt.Printf("%v\n", HashSetLen(HashSetSymmetricDifference(metals, period3)))
+    // => 9
+
+    fmt.Printf("%v %v\n",
+               HashSetIsSubset(HashSetIntersection(metals, period3), metals),
+               HashSetIsSubset(metals, period3))
+    // => true false
+
+
+    for k := range HashSetAll(HashSetIntersection(metals, period3)) {
+        HashSetRemove(metals, k)
+    }
+    fmt.Printf("%v %v\n", HashSetLen(metals), HashSetContains(metals, "sodium"))
+    // => 4 false
+
+
+    os.Exit(0)
+}
@@ -0,0 +1,483 @@
+package main
+
+
+// host$ go build hashset3.go
+// host$ ./hashset3
+
+
+import "fmt"
+import "iter"
+import "os"
+import "slices"
+
+
+type HashTableEntry[K, V comparable] struct {
+    k K
+    v V
+}
+
+
+type HashTable[K, V comparable] struct {
+    nentries int
+    nslots int
+    slots [][]HashTableEntry[K, V]
+    hash func(k K, m int) int
+    nresizes int
+}
+
+
+func HashTableNew[K, V comparable](nslots int,
+                                   hash func(k K, m int) int) *HashTable[K, V] {
+    h := new(HashTable[K, V])
+    h.nentries = 0
+    h.nslots = nslots
+    h.slots = make([][]HashTableEntry[K, V], nslots)
+    for i := range h.slots {
+        h.slots[i] = make([]HashTableEntry[K, V], 0)
+    }
+    h.hash = hash
+    return h
+}
+
+
+func (h *HashTable[K, V]) hashtablenewlayer(slot int) *HashTable[K, V] {
+    h2 := new(HashTable[K, V])
+    h2.nentries = h.nentries
+    h2.nslots = h.nslots
+    h2.slots = make([][]HashTableEntry[K, V], h2.nslots)
+    copy(h2.slots, h.slots)
+    h2.slots[slot] = make([]HashTableEntry[K, V], len(h.slots[slot]))
+    copy(h2.slots[slot], h.slots[slot])
+    h2.hash = h.hash
+    h2.nresizes = h.nresizes
+    return h2
+}
+
+
+func (h *HashTable[K, V]) Get(k K) (V, bool) {
+    slot := h.hash(k, h.nslots)
+    for _, e := range h.slots[slot] {
+        if e.k == k {
+            return e.v, true
+        }
+    }
+    return *new(V), false
+}
+
+
+// Iteration is in slot order, which changes whenever the table is resized.
+// Because the table is immutable, iterating while deriving new versions from
+// it is safe.
+func (h *HashTable[K, V]) All() iter.Seq2[K, V] {
+    return func(yield func(k K, v V) bool) {
+        for i, _ := range h.slots {
+            for _, e := range h.slots[i] {
+                if !yield(e.k, e.v) {
+                    return
+                }
+            }
+        }
+    }
+}
+
+
+func (h *HashTable[K, V]) Keys() iter.Seq[K] {
+    return func(yield func(k K) bool) {
+        for k, _ := range h.All() {
+            if !yield(k) {
+                return
+            }
+        }
+    }
+}
+
+
+func (h *HashTable[K, V]) Values() iter.Seq[V] {
+    return func(yield func(v V) bool) {
+        for _, v := range h.All() {
+            if !yield(v) {
+                return
+            }
+        }
+    }
+}
+
+
+func (h *HashTable[K, V]) LoadFactor() float32 {
+    return float32(h.nentries) / float32(h.nslots)
+}
+
+
+func remove[T any](v []T, i int) []T {
+    v[i] = v[len(v) - 1]
+    v = v[:len(v) - 1]
+    return v
+}
+
+
+func (h *HashTable[K, V]) remove(k K) *HashTable[K, V] {
+    slot := h.hash(k, h.nslots)
+    for i, e := range h.slots[slot] {
+        if e.k == k {
+            h2 := h.hashtablenewlayer(slot)
+            h2.slots[slot] = remove(h2.slots[slot], i)
+            h2.nentries--
+            return h2
+        }
+    }
+    return h
+}
+
+
+func (h *HashTable[K, V]) Remove(k K) *HashTable[K, V] {
+    h = h.remove(k)
+    if h.LoadFactor() < 0.3 && h.nslots > 1 {
+        h = h.Resize(h.nslots / 2)
+    }
+    return h
+}
+
+
+func (h *HashTable[K, V]) Resize(nslots int) *HashTable[K, V] {
+    t := HashTableNew[K, V](nslots, h.hash).Transient()
+    for i, _ := range h.slots {
+        for _, e := range h.slots[i] {
+            t.set(e.k, e.v)
+        }
+    }
+    h2 := t.Persistent()
+    h2.nresizes = h.nresizes + 1
+    return h2
+}
+
+
+func (h *HashTable[K, V]) set(k K, v V) *HashTable[K, V] {
+    slot := h.hash(k, h.nslots)
+    h2 := h.hashtablenewlayer(slot)
+    for i, e := range h2.slots[slot] {
+        if e.k == k {
+            h2.slots[slot][i].v = v
+            return h2
+        }
+    }
+    h2.slots[slot] = append(h2.slots[slot], HashTableEntry[K, V]{ k, v })
+    h2.nentries++
+    return h2
+}
+
+
+func (h *HashTable[K, V]) Set(k K, v V) *HashTable[K, V] {
+    h = h.set(k, v)
+    if h.LoadFactor() > 0.7 {
+        h = h.Resize(h.nslots * 2)
+    }
+    return h
+}
+
+
+// A transient is a mutable view of a HashTable for use during a build phase.
+// Slots are copied on first write and then mutated in place, so repeated
+// Set and Remove calls don't allocate a new layer each time.  Persistent()
+// freezes the transient back into an immutable HashTable and invalidates it.
+type HashTableTransient[K, V comparable] struct {
+    h *HashTable[K, V]
+    owned []bool
+}
+
+
+func (h *HashTable[K, V]) Transient() *HashTableTransient[K, V] {
+    t := new(HashTableTransient[K, V])
+    t.h = new(HashTable[K, V])
+    t.h.nentries = h.nentries
+    t.h.nslots = h.nslots
+    t.h.slots = make([][]HashTableEntry[K, V], h.nslots)
+    copy(t.h.slots, h.slots)
+    t.h.hash = h.hash
+    t.h.nresizes = h.nresizes
+    t.owned = make([]bool, h.nslots)
+    return t
+}
+
+
+func (t *HashTableTransient[K, V]) table() *HashTable[K, V] {
+    if t.h == nil {
+        panic("HashTableTransient used after Persistent")
+    }
+    return t.h
+}
+
+
+func (t *HashTableTransient[K, V]) own(slot int) {
+    if !t.owned[slot] {
+        s := make([]HashTableEntry[K, V], len(t.h.slots[slot]))
+        copy(s, t.h.slots[slot])
+        t.h.slots[slot] = s
+        t.owned[slot] = true
+    }
+}
+
+
+func (t *HashTableTransient[K, V]) Persistent() *HashTable[K, V] {
+    h := t.table()
+    t.h = nil
+    t.owned = nil
+    return h
+}
+
+
+func (t *HashTableTransient[K, V]) Get(k K) (V, bool) {
+    return t.table().Get(k)
+}
+
+
+func (t *HashTableTransient[K, V]) LoadFactor() float32 {
+    return t.table().LoadFactor()
+}
+
+
+func (t *HashTableTransient[K, V]) remove(k K) {
+    h := t.table()
+    slot := h.hash(k, h.nslots)
+    for i, e := range h.slots[slot] {
+        if e.k == k {
+            t.own(slot)
+            h.slots[slot] = remove(h.slots[slot], i)
+            h.nentries--
+            return
+        }
+    }
+}
+
+
+func (t *HashTableTransient[K, V]) Remove(k K) *HashTableTransient[K, V] {
+    t.remove(k)
+    if t.LoadFactor() < 0.3 && t.h.nslots > 1 {
+        t.Resize(t.h.nslots / 2)
+    }
+    return t
+}
+
+
+func (t *HashTableTransient[K, V]) Resize(nslots int) *HashTableTransient[K, V] {
+    h := t.table()
+    slots := make([][]HashTableEntry[K, V], nslots)
+    for i := range slots {
+        slots[i] = make([]HashTableEntry[K, V], 0)
+    }
+    for i, _ := range h.slots {
+        for _, e := range h.slots[i] {
+            slot := h.hash(e.k, nslots)
+            slots[slot] = append(slots[slot], e)
+        }
+    }
+    h.nslots = nslots
+    h.slots = slots
+    h.nresizes++
+    t.owned = make([]bool, nslots)
+    for i := range t.owned {
+        t.owned[i] = true
+    }
+    return t
+}
+
+
+func (t *HashTableTransient[K, V]) set(k K, v V) {
+    h := t.table()
+    slot := h.hash(k, h.nslots)
+    t.own(slot)
+    for i, e := range h.slots[slot] {
+        if e.k == k {
+            h.slots[slot][i].v = v
+            return
+        }
+    }
+    h.slots[slot] = append(h.slots[slot], HashTableEntry[K, V]{ k, v })
+    h.nentries++
+}
+
+
+func (t *HashTableTransient[K, V]) Set(k K, v V) *HashTableTransient[K, V] {
+    t.set(k, v)
+    if t.LoadFactor() > 0.7 {
+        t.Resize(t.h.nslots * 2)
+    }
+    return t
+}
+
+
+// Halves the slots, as many times as Remove would have, in one resize.
+func (t *HashTableTransient[K, V]) shrink() {
+    h := t.table()
+    nslots := h.nslots
+    for nslots > 1 && float32(h.nentries) / float32(nslots) < 0.3 {
+        nslots /= 2
+    }
+    if nslots != h.nslots {
+        t.Resize(nslots)
+    }
+}
+
+
+// An immutable set of keys, stored as the keys of a persistent HashTable
+// from hashtable3.go with empty values.  Add and Remove return a new set
+// sharing all but one slot with the old one.
+type HashSet[K comparable] struct {
+    h *HashTable[K, struct{}]
+}
+
+
+func HashSetNew[K comparable](nslots int,
+                              hash func(k K, m int) int) *HashSet[K] {
+    return &HashSet[K]{ HashTableNew[K, struct{}](nslots, hash) }
+}
+
+
+func HashSetOf[K comparable](hash func(k K, m int) int, ks ...K) *HashSet[K] {
+    s := HashSetNew(1, hash)
+    for _, k := range ks {
+        s = s.Add(k)
+    }
+    return s
+}
+
+
+func (s *HashSet[K]) String() string {
+    return fmt.Sprintf("%v", slices.Collect(s.h.Keys()))
+}
+
+
+func (s *HashSet[K]) Add(k K) *HashSet[K] {
+    h := s.h.Set(k, struct{}{})
+    if h.nentries == s.h.nentries {
+        return s
+    }
+    return &HashSet[K]{ h }
+}
+
+
+func (s *HashSet[K]) Remove(k K) *HashSet[K] {
+    h := s.h.Remove(k)
+    if h == s.h {
+        return s
+    }
+    return &HashSet[K]{ h }
+}
+
+
+func (s *HashSet[K]) Contains(k K) bool {
+    _, ok := s.h.Get(k)
+    return ok
+}
+
+
+func (s *HashSet[K]) Len() int {
+    return s.h.nentries
+}
+
+
+func (s *HashSet[K]) All() iter.Seq[K] {
+    return s.h.Keys()
+}
+
+
+// Keys of s for which f is false are removed, so the result shares s's
+// unchanged slots.
+func (s *HashSet[K]) Filter(f func(k K) bool) *HashSet[K] {
+    s2 := s
+    for k := range s.All() {
+        if !f(k) {
+            s2 = s2.Remove(k)
+        }
+    }
+    return s2
+}
+
+
+func (a *HashSet[K]) Union(b *HashSet[K]) *HashSet[K] {
+    if a.Len() < b.Len() {
+        a, b = b, a
+    }
+    s := a
+    for k := range b.All() {
+        s = s.Add(k)
+    }
+    return s
+}
+
+
+func (a *HashSet[K]) Intersection(b *HashSet[K]) *HashSet[K] {
+    return a.Filter(b.Contains)
+}
+
+
+func (a *HashSet[K]) Difference(b *HashSet[K]) *HashSet[K] {
+    return a.Filter(func(k K) bool { return !b.Contains(k) })
+}
+
+
+func (a *HashSet[K]) SymmetricDifference(b *HashSet[K]) *HashSet[K] {
+    return a.Difference(b).Union(b.Difference(a))
+}
+
+
+func (a *HashSet[K]) IsSubset(b *HashSet[K]) bool {
+    if a.Len() > b.Len() {
+        return false
+    }
+    for k := range a.All() {
+        if !b.Contains(k) {
+            return false
+        }
+    }
+    return true
+}
+
+
+func hashsumchars(s string, m int) int {
+    sum := 0
+    for _, e := range s {
+        sum += int(e)
+    }
+    return sum % m
+}
+
+
+func main() {
+    metals := HashSetOf(hashsumchars,
+                        "lithium", "beryllium", "sodium", "magnesium",
+                        "aluminium", "potassium", "calcium")
+    period3 := HashSetOf(hashsumchars,
+                         "sodium", "magnesium", "aluminium", "silicon",
+                         "phosphorus", "sulfur", "chlorine", "argon")
+    fmt.Printf("%v %v\n", metals.Len(), period3.Len())
+    // => 7 8
+
+    fmt.Printf("%v %v\n", metals.Contains("sodium"), metals.Contains("argon"))
+    // => true false
+
+    fmt.Printf("%v\n", metals.Union(period3).Len())
+    // => 12
+    fmt.Printf("%v\n", metals.Intersection(period3))
+    // => [sodium aluminium magnesium]
+    fmt.Printf("%v\n", metals.Difference(period3).Len())
+    // => 4
+    fmt.Printf("%v\n", metals.SymmetricDifference(period3).Len())
+    // => 9
+
+    fmt.Printf("%v %v\n",
+               metals.Intersection(period3).IsSubset(metals),
+               metals.IsSubset(period3))
+    // => true false
+
+
+    metals2 := metals.Remove("sodium").Add("rubidium")
+    fmt.Printf("%v %v\n", metals.Contains("sodium"), metals.Contains("rubidium"))
+    // => true false
+    fmt.Printf("%v %v\n", metals2.Contains("sodium"), metals2.Contains("rubidium"))
+    // => false true
+    fmt.Printf("%v\n", metals.Add("sodium") == metals)
+    // => true
+
+
+    os.Exit(0)
+}
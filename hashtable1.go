@@ -5,25 +5,93 @@ package main
 
 
 import "fmt"
+import "math"
 import "os"
+import "slices"
+import "strings"
 
 
-type HashTableEntry[K, V comparable] struct {
+type HashTableEntry[K any, V comparable] struct {
     k K
     v V
 }
 
 
-type HashTable[K, V comparable] struct {
+// The table grows by growth when the load factor rises above grow, and
+// shrinks by the same factor when it falls below shrink, but never below
+// minslots.  shrink * growth must be less than grow so that a table that has
+// just shrunk can't immediately grow again, and vice versa.
+type HashTablePolicy struct {
+    grow float32
+    shrink float32
+    growth float32
+    minslots int
+    expected int
+    incremental int
+}
+
+
+type HashTableOption func(p *HashTablePolicy)
+
+
+func HashTableWithThresholds(grow, shrink float32) HashTableOption {
+    return func(p *HashTablePolicy) {
+        p.grow = grow
+        p.shrink = shrink
+    }
+}
+
+
+func HashTableWithGrowthFactor(growth float32) HashTableOption {
+    return func(p *HashTablePolicy) {
+        p.growth = growth
+    }
+}
+
+
+func HashTableWithMinSlots(minslots int) HashTableOption {
+    return func(p *HashTablePolicy) {
+        p.minslots = minslots
+    }
+}
+
+
+// Instead of rehashing every entry at once, a resize keeps the old slots
+// alongside the new ones and each Set or Remove moves the key's own old
+// bucket plus up to nbuckets more, bounding the cost of any one call.
+// nbuckets needs to be at least 1 / ((growth - 1) * grow), 2 by default, for
+// each migration to finish before the next resize is due.
+func HashTableWithIncrementalResize(nbuckets int) HashTableOption {
+    return func(p *HashTablePolicy) {
+        p.incremental = nbuckets
+    }
+}
+
+
+// Pre-sizes the table so that n entries can be set without a resize.
+func HashTableWithExpected(n int) HashTableOption {
+    return func(p *HashTablePolicy) {
+        p.expected = n
+    }
+}
+
+
+type HashTable[K any, V comparable] struct {
     nentries int
     nslots int
     slots [][]HashTableEntry[K, V]
     hash func(k K, m int) int
+    equal func(x, y K) bool
+    policy HashTablePolicy
+    oldslots [][]HashTableEntry[K, V]
+    migrated int
 }
 
 
-func HashTableNew[K, V comparable](nslots int,
-                                   hash func(k K, m int) int) *HashTable[K, V] {
+func hashtablenew[K any, V comparable](nslots int,
+                                       hash func(k K, m int) int,
+                                       equal func(x, y K) bool,
+                                       policy HashTablePolicy) *HashTable[K, V] {
     h := new(HashTable[K, V])
     h.nentries = 0
     h.nslots = nslots
@@ -32,14 +100,76 @@ func HashTableNew[K, V comparable](nslots int,
         h.slots[i] = make([]HashTableEntry[K, V], 0)
     }
     h.hash = hash
+    h.equal = equal
+    h.policy = policy
     return h
 }
 
 
-func HashTableGet[K, V comparable](h *HashTable[K, V], k K) (V, bool) {
+func HashTableNew[K, V comparable](nslots int,
+                                   hash func(k K, m int) int,
+                                   options ...HashTableOption) *HashTable[K, V] {
+    return HashTableNewFunc[K, V](nslots,
+                                  hash,
+                                  func(x, y K) bool { return x == y },
+                                  options...)
+}
+
+
+// For keys that aren't comparable, or that need comparing other than with
+// ==, such as case-insensitive strings.  Keys that are equal must hash to
+// the same slot.
+func HashTableNewFunc[K any, V comparable](nslots int,
+                                           hash func(k K, m int) int,
+                                           equal func(x, y K) bool,
+                                           options ...HashTableOption) *HashTable[K, V] {
+    policy := HashTablePolicy{ 0.7, 0.3, 2, nslots, 0, 0 }
+    for _, option := range options {
+        option(&policy)
+    }
+    if policy.minslots < 1 ||
+       policy.growth <= 1 ||
+       policy.grow <= 0 ||
+       policy.shrink < 0 ||
+       policy.incremental < 0 ||
+       policy.shrink * policy.growth >= policy.grow {
+        panic(fmt.Sprintf("HashTableNew: invalid policy %+v", policy))
+    }
+    nslots = max(nslots, policy.minslots, policy.slotsfor(policy.expected))
+    return hashtablenew[K, V](nslots, hash, equal, policy)
+}
+
+
+type Hasher[K any] interface {
+    Hash(k K, m int) int
+    Equal(x, y K) bool
+}
+
+
+func HashTableNewHasher[K any, V comparable](nslots int,
+                                             hasher Hasher[K],
+                                             options ...HashTableOption) *HashTable[K, V] {
+    return HashTableNewFunc[K, V](nslots, hasher.Hash, hasher.Equal, options...)
+}
+
+
+// The number of slots needed to hold n entries without growing.
+func (p HashTablePolicy) slotsfor(n int) int {
+    return max(p.minslots, int(math.Ceil(float64(float32(n) / p.grow))))
+}
+
+
+func HashTableGet[K any, V comparable](h *HashTable[K, V], k K) (V, bool) {
+    if h.oldslots != nil {
+        for _, e := range h.oldslots[h.hash(k, len(h.oldslots))] {
+            if h.equal(e.k, k) {
+                return e.v, true
+            }
+        }
+    }
     slot := h.hash(k, h.nslots)
     for _, e := range h.slots[slot] {
-        if e.k == k {
+        if h.equal(e.k, k) {
             return e.v, true
         }
     }
@@ -47,10 +177,11 @@ func HashTableGet[K, V comparable](h *HashTable[K, V], k K) (V, bool) {
 }
 
 
-func HashTableKeys[K, V comparable](h *HashTable[K, V]) []K {
+func HashTableKeys[K any, V comparable](h *HashTable[K, V]) []K {
     keys := make([]K, 0, h.nentries)
-    for i, _ := range h.slots {
-        for _, e := range h.slots[i] {
+    slots := hashtableslots(h)
+    for i, _ := range slots {
+        for _, e := range slots[i] {
             keys = append(keys, e.k)
         }
     }
@@ -58,7 +189,16 @@ func HashTableKeys[K, V comparable](h *HashTable[K, V]) []K {
 }
 
 
-func HashTableLoadFactor[K, V comparable](h *HashTable[K, V]) float32 {
+// All buckets, including those still waiting to be migrated.
+func hashtableslots[K any, V comparable](h *HashTable[K, V]) [][]HashTableEntry[K, V] {
+    if h.oldslots == nil {
+        return h.slots
+    }
+    return append(h.oldslots[:len(h.oldslots):len(h.oldslots)], h.slots...)
+}
+
+
+func HashTableLoadFactor[K any, V comparable](h *HashTable[K, V]) float32 {
     return float32(h.nentries) / float32(h.nslots)
 }
 
@@ -70,11 +210,62 @@ func remove[T any](v []T, i int) []T {
 }
 
 
-func hashtableremove[K, V comparable](h *HashTable[K, V],
-                                      k K) *HashTable[K, V] {
+func hashtablemigratebucket[K any, V comparable](h *HashTable[K, V], slot int) {
+    for _, e := range h.oldslots[slot] {
+        slot2 := h.hash(e.k, h.nslots)
+        h.slots[slot2] = append(h.slots[slot2], e)
+    }
+    h.oldslots[slot] = nil
+}
+
+
+// Moves k's old bucket, so that it only needs looking for in the new slots,
+// then moves up to policy.incremental more.
+func hashtablemigrate[K any, V comparable](h *HashTable[K, V], k K) {
+    if h.oldslots == nil {
+        return
+    }
+    hashtablemigratebucket(h, h.hash(k, len(h.oldslots)))
+    for n := 0;
+        n < h.policy.incremental && h.migrated < len(h.oldslots);
+        n++ {
+        hashtablemigratebucket(h, h.migrated)
+        h.migrated++
+    }
+    if h.migrated == len(h.oldslots) {
+        h.oldslots = nil
+        h.migrated = 0
+    }
+}
+
+
+// Resizes all at once, or starts an incremental resize if the policy asks
+// for one.  A resize already under way is left to finish first.
+func hashtableresize[K any, V comparable](h *HashTable[K, V],
+                                          nslots int) *HashTable[K, V] {
+    if h.policy.incremental == 0 {
+        return HashTableResize(h, nslots)
+    }
+    if h.oldslots != nil {
+        return h
+    }
+    h.oldslots = h.slots
+    h.migrated = 0
+    h.nslots = nslots
+    h.slots = make([][]HashTableEntry[K, V], nslots)
+    for i := range h.slots {
+        h.slots[i] = make([]HashTableEntry[K, V], 0)
+    }
+    return h
+}
+
+
+func hashtableremove[K any, V comparable](h *HashTable[K, V],
+                                          k K) *HashTable[K, V] {
+    hashtablemigrate(h, k)
     slot := h.hash(k, h.nslots)
     for i, e := range h.slots[slot] {
-        if e.k == k {
+        if h.equal(e.k, k) {
             h.slots[slot] = remove(h.slots[slot], i)
             h.nentries--
             return h
@@ -84,21 +275,46 @@ func hashtableremove[K, V comparable](h *HashTable[K, V],
 }
 
 
-func HashTableRemove[K, V comparable](h *HashTable[K, V],
-                                      k K) *HashTable[K, V] {
+func HashTableRemove[K any, V comparable](h *HashTable[K, V],
+                                          k K) *HashTable[K, V] {
     h = hashtableremove(h, k)
-    if HashTableLoadFactor(h) < 0.3 {
-        h = HashTableResize(h, h.nslots / 2)
+    if HashTableLoadFactor(h) < h.policy.shrink &&
+       h.nslots > h.policy.minslots {
+        nslots := int(float32(h.nslots) / h.policy.growth)
+        h = hashtableresize(h, max(nslots, h.policy.minslots))
+    }
+    return h
+}
+
+
+// Shrinks the table to the fewest slots that hold its entries without
+// growing.
+func HashTableShrink[K any, V comparable](h *HashTable[K, V]) *HashTable[K, V] {
+    nslots := h.policy.slotsfor(h.nentries)
+    if nslots < h.nslots {
+        h = HashTableResize(h, nslots)
     }
     return h
 }
 
 
-func HashTableResize[K, V comparable](h *HashTable[K, V],
-                                      nslots int) *HashTable[K, V] {
-    h2 := HashTableNew[K, V](nslots, h.hash)
-    for i, _ := range h.slots {
-        for _, e := range h.slots[i] {
+// Grows the table so that it holds n entries without a further resize.
+func HashTableReserve[K any, V comparable](h *HashTable[K, V],
+                                           n int) *HashTable[K, V] {
+    nslots := h.policy.slotsfor(n)
+    if nslots > h.nslots {
+        h = HashTableResize(h, nslots)
+    }
+    return h
+}
+
+
+func HashTableResize[K any, V comparable](h *HashTable[K, V],
+                                          nslots int) *HashTable[K, V] {
+    h2 := hashtablenew[K, V](nslots, h.hash, h.equal, h.policy)
+    slots := hashtableslots(h)
+    for i, _ := range slots {
+        for _, e := range slots[i] {
             h2 = hashtableset(h2, e.k, e.v)
         }
     }
@@ -106,11 +322,12 @@ func HashTableResize[K, V comparable](h *HashTable[K, V],
 }
 
 
-func hashtableset[K, V comparable](h *HashTable[K, V],
-                                   k K, v V) *HashTable[K, V] {
+func hashtableset[K any, V comparable](h *HashTable[K, V],
+                                       k K, v V) *HashTable[K, V] {
+    hashtablemigrate(h, k)
     slot := h.hash(k, h.nslots)
     for i, e := range h.slots[slot] {
-        if e.k == k {
+        if h.equal(e.k, k) {
             h.slots[slot][i].v = v
             return h
         }
@@ -121,16 +338,99 @@ func hashtableset[K, V comparable](h *HashTable[K, V],
 }
 
 
-func HashTableSet[K, V comparable](h *HashTable[K, V],
-                                   k K, v V) *HashTable[K, V] {
+func HashTableSet[K any, V comparable](h *HashTable[K, V],
+                                       k K, v V) *HashTable[K, V] {
     h = hashtableset(h, k, v)
-    if HashTableLoadFactor(h) > 0.7 {
-        h = HashTableResize(h, h.nslots * 2)
+    if HashTableLoadFactor(h) > h.policy.grow {
+        nslots := int(math.Ceil(float64(float32(h.nslots) * h.policy.growth)))
+        h = hashtableresize(h, max(nslots, h.nslots + 1))
     }
     return h
 }
 
 
+// The set operations below leave their arguments unchanged and return a new
+// table with a's policy.
+
+
+// Keys in both tables take the value resolve returns.
+func HashTableMerge[K any, V comparable](a, b *HashTable[K, V],
+                                         resolve func(k K, va, vb V) V) *HashTable[K, V] {
+    h := HashTableResize(a, a.nslots)
+    for _, k := range HashTableKeys(b) {
+        vb, _ := HashTableGet(b, k)
+        if va, ok := HashTableGet(a, k); ok {
+            h = HashTableSet(h, k, resolve(k, va, vb))
+        } else {
+            h = HashTableSet(h, k, vb)
+        }
+    }
+    return h
+}
+
+
+func HashTableFilter[K any, V comparable](h *HashTable[K, V],
+                                          f func(k K, v V) bool) *HashTable[K, V] {
+    h2 := hashtablenew[K, V](h.policy.minslots, h.hash, h.equal, h.policy)
+    slots := hashtableslots(h)
+    for i, _ := range slots {
+        for _, e := range slots[i] {
+            if f(e.k, e.v) {
+                h2 = HashTableSet(h2, e.k, e.v)
+            }
+        }
+    }
+    return h2
+}
+
+
+// Entries of a whose keys are in b.
+func HashTableIntersect[K any, V comparable](a, b *HashTable[K, V]) *HashTable[K, V] {
+    return HashTableFilter(a, func(k K, _ V) bool {
+                                  _, ok := HashTableGet(b, k)
+                                  return ok
+                              })
+}
+
+
+// Entries of a whose keys aren't in b.
+func HashTableDifference[K any, V comparable](a, b *HashTable[K, V]) *HashTable[K, V] {
+    return HashTableFilter(a, func(k K, _ V) bool {
+                                  _, ok := HashTableGet(b, k)
+                                  return !ok
+                              })
+}
+
+
+func HashTableEqual[K any, V comparable](a, b *HashTable[K, V]) bool {
+    if a.nentries != b.nentries {
+        return false
+    }
+    slots := hashtableslots(a)
+    for i, _ := range slots {
+        for _, e := range slots[i] {
+            if v, ok := HashTableGet(b, e.k); !ok || v != e.v {
+                return false
+            }
+        }
+    }
+    return true
+}
+
+
+func HashTableMapValues[K any, V, W comparable](h *HashTable[K, V],
+                                                f func(k K, v V) W) *HashTable[K, W] {
+    h2 := hashtablenew[K, W](h.nslots, h.hash, h.equal, h.policy)
+    slots := hashtableslots(h)
+    for i, _ := range slots {
+        for _, e := range slots[i] {
+            h2 = hashtableset(h2, e.k, f(e.k, e.v))
+        }
+    }
+    return h2
+}
+
+
 func hashsumchars(s string, m int) int {
     sum := 0
     for _, e := range s {
@@ -140,6 +440,44 @@ func hashsumchars(s string, m int) int {
 }
 
 
+type FoldedStrings struct{}
+
+
+func (FoldedStrings) Hash(s string, m int) int {
+    return hashsumchars(strings.ToLower(s), m)
+}
+
+
+func (FoldedStrings) Equal(x, y string) bool {
+    return strings.EqualFold(x, y)
+}
+
+
+func hashints(l []int, m int) int {
+    x := 0
+    for _, e := range l {
+        x = (x * 31 + e) % m
+    }
+    return (x + m) % m
+}
+
+
+// NaN equals itself and -0 equals +0, so that every float can be a key.
+func hashfloat(f float64, m int) int {
+    if f == 0 {
+        f = 0
+    } else if math.IsNaN(f) {
+        f = math.NaN()
+    }
+    return int(math.Float64bits(f) % uint64(m))
+}
+
+
+func equalfloat(x, y float64) bool {
+    return x == y || math.IsNaN(x) && math.IsNaN(y)
+}
+
+
 func main() {
     elements := []string{ "hydrogen",
                           "helium",
@@ -207,5 +545,100 @@ func main() {
     fmt.Printf("%p %v load %v\n", h8, h8, HashTableLoadFactor(h8))
 
 
+    hashtable = HashTableNew[string, int](1,
+                                          hashsumchars,
+                                          HashTableWithThresholds(0.75, 0.25),
+                                          HashTableWithGrowthFactor(1.5),
+                                          HashTableWithExpected(len(elements)))
+    fmt.Printf("%v %v\n", hashtable.nslots, HashTableLoadFactor(hashtable))
+    // => 27 0
+
+    for _, e := range elements {
+        hashtable = HashTableSet(hashtable, e, len(e))
+    }
+    fmt.Printf("%v %v\n", hashtable.nslots, HashTableLoadFactor(hashtable))
+    // => 27 0.7407407
+
+    for _, e := range elements[:15] {
+        hashtable = HashTableRemove(hashtable, e)
+    }
+    hashtable = HashTableShrink(hashtable)
+    fmt.Printf("%v %v\n", hashtable.nslots, HashTableLoadFactor(hashtable))
+    // => 7 0.71428573
+
+    hashtable = HashTableReserve(hashtable, 100)
+    fmt.Printf("%v %v\n", hashtable.nslots, HashTableLoadFactor(hashtable))
+    // => 134 0.03731343
+
+
+    hashtable = HashTableNew[string, int](1,
+                                          hashsumchars,
+                                          HashTableWithIncrementalResize(2))
+    for _, e := range elements {
+        hashtable = HashTableSet(hashtable, e, len(e))
+        fmt.Printf("%v %v %v\n",
+                   len(hashtable.oldslots),
+                   hashtable.nslots,
+                   HashTableLoadFactor(hashtable))
+    }
+
+    v, ok = HashTableGet(hashtable, "oxygen")
+    fmt.Printf("%v %v %v\n", v, ok, len(HashTableKeys(hashtable)))
+    // => 6 true 20
+
+
+    nobles := HashTableNew[string, int](1, hashsumchars)
+    for _, e := range []string{ "helium", "neon", "argon", "krypton" } {
+        nobles = HashTableSet(nobles, e, 0)
+    }
+    merged := HashTableMerge(hashtable,
+                             nobles,
+                             func(k string, va, vb int) int {
+                                 return va + vb
+                             })
+    fmt.Printf("%v %v\n",
+               HashTableKeys(HashTableDifference(merged, hashtable)),
+               len(HashTableKeys(HashTableIntersect(hashtable, nobles))))
+    // => [krypton] 3
+    fmt.Printf("%v %v\n",
+               HashTableEqual(merged, hashtable),
+               HashTableEqual(HashTableRemove(merged, "krypton"), hashtable))
+    // => false true
+    long := HashTableFilter(hashtable, func(k string, v int) bool {
+                                           return v > 8
+                                       })
+    fmt.Printf("%v\n", len(HashTableKeys(long)))
+    // => 5
+    lengths := HashTableMapValues(hashtable, func(k string, v int) float64 {
+                                                 return float64(v) / 2
+                                             })
+    v2, ok := HashTableGet(lengths, "oxygen")
+    fmt.Printf("%v %v\n", v2, ok)
+    // => 3 true
+
+
+    folded := HashTableNewHasher[string, int](1, FoldedStrings{})
+    folded = HashTableSet(folded, "Oxygen", 8)
+    folded = HashTableSet(folded, "OXYGEN", 16)
+    v, ok = HashTableGet(folded, "oxygen")
+    fmt.Printf("%v %v %v\n", v, ok, HashTableKeys(folded))
+    // => 16 true [Oxygen]
+
+    isotopes := HashTableNewFunc[[]int, string](1, hashints, slices.Equal[[]int])
+    isotopes = HashTableSet(isotopes, []int{ 8, 16 }, "oxygen-16")
+    isotopes = HashTableSet(isotopes, []int{ 8, 18 }, "oxygen-18")
+    v3, ok := HashTableGet(isotopes, []int{ 8, 18 })
+    fmt.Printf("%v %v\n", v3, ok)
+    // => oxygen-18 true
+
+    floats := HashTableNewFunc[float64, string](1, hashfloat, equalfloat)
+    floats = HashTableSet(floats, math.NaN(), "nan")
+    floats = HashTableSet(floats, math.Copysign(0, -1), "zero")
+    v3, ok = HashTableGet(floats, math.NaN())
+    v4, ok2 := HashTableGet(floats, 0)
+    fmt.Printf("%v %v %v %v %v\n", v3, ok, v4, ok2, len(HashTableKeys(floats)))
+    // => nan true zero true 2
+
+
     os.Exit(0)
 }
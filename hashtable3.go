@@ -4,8 +4,66 @@ package main
 // https://opendsa-server.cs.vt.edu/ODSA/Books/CS3/html/HashIntro.html
 
 
+import "bytes"
+import "encoding/binary"
+import "encoding/gob"
+import "encoding/json"
+import "errors"
 import "fmt"
+import "hash/crc32"
+import "hash/fnv"
+import "io"
+import "iter"
+import "math"
 import "os"
+import "reflect"
+import "slices"
+import "sync/atomic"
+
+
+type Ordered interface {
+    ~int | ~int8 | ~int16 | ~int32 | ~int64 |
+    ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 |
+    ~uintptr | ~float32 | ~float64 | ~string
+}
+
+
+func Compare[A Ordered](x, y A) int {
+    booltoint := func (b bool) int {
+        if b {
+            return 1
+        } else {
+            return 0
+        }
+    }
+
+    return booltoint(x > y) - booltoint(x < y)
+}
+
+
+func QuickSort[A Ordered](compare func (x, y A) int, l []A) []A {
+    if len(l) == 0 || len(l) == 1 {
+        return l
+    }
+
+    pivot := l[0]
+    left := make([]A, 0, len(l) / 2)
+    right := make([]A, 0, len(l) / 2)
+
+    for _, e := range l[1:] {
+        if compare(e, pivot) <= 0 {
+            left = append(left, e)
+        } else {
+            right = append(right, e)
+        }
+    }
+
+    l1 := make([]A, 0, len(l))
+    l1 = append(l1, QuickSort(compare, left)...)
+    l1 = append(l1, pivot)
+    l1 = append(l1, QuickSort(compare, right)...)
+    return l1
+}
 
 
 type HashTableEntry[K, V comparable] struct {
@@ -19,6 +77,7 @@ type HashTable[K, V comparable] struct {
     nslots int
     slots [][]HashTableEntry[K, V]
     hash func(k K, m int) int
+    nresizes int
 }
 
 
@@ -45,17 +104,80 @@ func (h *HashTable[K, V]) hashtablenewlayer(slot int) *HashTable[K, V] {
     h2.slots[slot] = make([]HashTableEntry[K, V], len(h.slots[slot]))
     copy(h2.slots[slot], h.slots[slot])
     h2.hash = h.hash
+    h2.nresizes = h.nresizes
     return h2
 }
 
 
 func (h *HashTable[K, V]) String() string {
-    return fmt.Sprintf("&{%v %v %v %v} load %v",
+    return fmt.Sprintf("&{%v %v} load %v",
                        h.nentries,
-		       h.nslots,
-		       h.slots,
-		       h.hash,
-		       h.LoadFactor())
+                       h.nslots,
+                       h.LoadFactor())
+}
+
+
+type HashTableStats struct {
+    nentries int
+    nslots int
+    loadfactor float32
+    histogram []int
+    maxchain int
+    empty float32
+    nresizes int
+    uniformity float64
+}
+
+
+// histogram[n] is the number of slots holding n entries.  uniformity is the
+// chi-squared statistic of the slot lengths against a uniform distribution
+// divided by its degrees of freedom: close to 1 for a good hash function and
+// much larger for a poor one.
+func hashtablestats(nentries int, lengths []int) HashTableStats {
+    var stats HashTableStats
+    stats.nentries = nentries
+    stats.nslots = len(lengths)
+    stats.loadfactor = float32(nentries) / float32(len(lengths))
+    for _, n := range lengths {
+        stats.maxchain = max(stats.maxchain, n)
+    }
+    stats.histogram = make([]int, stats.maxchain + 1)
+    expected := float64(nentries) / float64(len(lengths))
+    chisquared := 0.0
+    for _, n := range lengths {
+        stats.histogram[n]++
+        chisquared += (float64(n) - expected) * (float64(n) - expected)
+    }
+    stats.empty = float32(stats.histogram[0]) / float32(len(lengths))
+    if nentries > 0 && len(lengths) > 1 {
+        stats.uniformity = chisquared / expected / float64(len(lengths) - 1)
+    }
+    return stats
+}
+
+
+func (h *HashTable[K, V]) Stats() HashTableStats {
+    lengths := make([]int, h.nslots)
+    for i, _ := range h.slots {
+        lengths[i] = len(h.slots[i])
+    }
+    stats := hashtablestats(h.nentries, lengths)
+    stats.nresizes = h.nresizes
+    return stats
+}
+
+
+// For use from tests: reports whether hash spreads keys over nslots slots
+// markedly worse than a uniform hash would.
+func HashTablePoorHash[K comparable](hash func(k K, m int) int,
+                                     keys []K,
+                                     nslots int) (HashTableStats, bool) {
+    lengths := make([]int, nslots)
+    for _, k := range keys {
+        lengths[hash(k, nslots)]++
+    }
+    stats := hashtablestats(len(keys), lengths)
+    return stats, stats.uniformity > 1 + 3 * math.Sqrt(2 / float64(nslots - 1))
 }
 
 
@@ -70,14 +192,47 @@ func (h *HashTable[K, V]) Get(k K) (V, bool) {
 }
 
 
-func (h *HashTable[K, V]) Keys() []K {
-    keys := make([]K, 0, h.nentries)
-    for i, _ := range h.slots {
-        for _, e := range h.slots[i] {
-            keys = append(keys, e.k)
+// Iteration is in slot order, which changes whenever the table is resized.
+// Because the table is immutable, iterating while deriving new versions from
+// it is safe.
+func (h *HashTable[K, V]) All() iter.Seq2[K, V] {
+    return func(yield func(k K, v V) bool) {
+        for i, _ := range h.slots {
+            for _, e := range h.slots[i] {
+                if !yield(e.k, e.v) {
+                    return
+                }
+            }
         }
     }
-    return keys
+}
+
+
+func (h *HashTable[K, V]) Keys() iter.Seq[K] {
+    return func(yield func(k K) bool) {
+        for k, _ := range h.All() {
+            if !yield(k) {
+                return
+            }
+        }
+    }
+}
+
+
+func (h *HashTable[K, V]) Values() iter.Seq[V] {
+    return func(yield func(v V) bool) {
+        for _, v := range h.All() {
+            if !yield(v) {
+                return
+            }
+        }
+    }
+}
+
+
+// Keys in an order that doesn't depend on the table's size.
+func SortedKeys[K Ordered, V comparable](h *HashTable[K, V]) []K {
+    return QuickSort(Compare[K], slices.Collect(h.Keys()))
 }
 
 
@@ -109,7 +264,7 @@ func (h *HashTable[K, V]) remove(k K) *HashTable[K, V] {
 
 func (h *HashTable[K, V]) Remove(k K) *HashTable[K, V] {
     h = h.remove(k)
-    if h.LoadFactor() < 0.3 {
+    if h.LoadFactor() < 0.3 && h.nslots > 1 {
         h = h.Resize(h.nslots / 2)
     }
     return h
@@ -117,12 +272,14 @@ func (h *HashTable[K, V]) Remove(k K) *HashTable[K, V] {
 
 
 func (h *HashTable[K, V]) Resize(nslots int) *HashTable[K, V] {
-    h2 := HashTableNew[K, V](nslots, h.hash)
+    t := HashTableNew[K, V](nslots, h.hash).Transient()
     for i, _ := range h.slots {
         for _, e := range h.slots[i] {
-            h2 = h2.set(e.k, e.v)
+            t.set(e.k, e.v)
         }
     }
+    h2 := t.Persistent()
+    h2.nresizes = h.nresizes + 1
     return h2
 }
 
@@ -151,6 +308,612 @@ func (h *HashTable[K, V]) Set(k K, v V) *HashTable[K, V] {
 }
 
 
+// A transient is a mutable view of a HashTable for use during a build phase.
+// Slots are copied on first write and then mutated in place, so repeated
+// Set and Remove calls don't allocate a new layer each time.  Persistent()
+// freezes the transient back into an immutable HashTable and invalidates it.
+type HashTableTransient[K, V comparable] struct {
+    h *HashTable[K, V]
+    owned []bool
+}
+
+
+func (h *HashTable[K, V]) Transient() *HashTableTransient[K, V] {
+    t := new(HashTableTransient[K, V])
+    t.h = new(HashTable[K, V])
+    t.h.nentries = h.nentries
+    t.h.nslots = h.nslots
+    t.h.slots = make([][]HashTableEntry[K, V], h.nslots)
+    copy(t.h.slots, h.slots)
+    t.h.hash = h.hash
+    t.h.nresizes = h.nresizes
+    t.owned = make([]bool, h.nslots)
+    return t
+}
+
+
+func (t *HashTableTransient[K, V]) table() *HashTable[K, V] {
+    if t.h == nil {
+        panic("HashTableTransient used after Persistent")
+    }
+    return t.h
+}
+
+
+func (t *HashTableTransient[K, V]) own(slot int) {
+    if !t.owned[slot] {
+        s := make([]HashTableEntry[K, V], len(t.h.slots[slot]))
+        copy(s, t.h.slots[slot])
+        t.h.slots[slot] = s
+        t.owned[slot] = true
+    }
+}
+
+
+func (t *HashTableTransient[K, V]) Persistent() *HashTable[K, V] {
+    h := t.table()
+    t.h = nil
+    t.owned = nil
+    return h
+}
+
+
+func (t *HashTableTransient[K, V]) Get(k K) (V, bool) {
+    return t.table().Get(k)
+}
+
+
+func (t *HashTableTransient[K, V]) LoadFactor() float32 {
+    return t.table().LoadFactor()
+}
+
+
+func (t *HashTableTransient[K, V]) remove(k K) {
+    h := t.table()
+    slot := h.hash(k, h.nslots)
+    for i, e := range h.slots[slot] {
+        if e.k == k {
+            t.own(slot)
+            h.slots[slot] = remove(h.slots[slot], i)
+            h.nentries--
+            return
+        }
+    }
+}
+
+
+func (t *HashTableTransient[K, V]) Remove(k K) *HashTableTransient[K, V] {
+    t.remove(k)
+    if t.LoadFactor() < 0.3 && t.h.nslots > 1 {
+        t.Resize(t.h.nslots / 2)
+    }
+    return t
+}
+
+
+func (t *HashTableTransient[K, V]) Resize(nslots int) *HashTableTransient[K, V] {
+    h := t.table()
+    slots := make([][]HashTableEntry[K, V], nslots)
+    for i := range slots {
+        slots[i] = make([]HashTableEntry[K, V], 0)
+    }
+    for i, _ := range h.slots {
+        for _, e := range h.slots[i] {
+            slot := h.hash(e.k, nslots)
+            slots[slot] = append(slots[slot], e)
+        }
+    }
+    h.nslots = nslots
+    h.slots = slots
+    h.nresizes++
+    t.owned = make([]bool, nslots)
+    for i := range t.owned {
+        t.owned[i] = true
+    }
+    return t
+}
+
+
+func (t *HashTableTransient[K, V]) set(k K, v V) {
+    h := t.table()
+    slot := h.hash(k, h.nslots)
+    t.own(slot)
+    for i, e := range h.slots[slot] {
+        if e.k == k {
+            h.slots[slot][i].v = v
+            return
+        }
+    }
+    h.slots[slot] = append(h.slots[slot], HashTableEntry[K, V]{ k, v })
+    h.nentries++
+}
+
+
+func (t *HashTableTransient[K, V]) Set(k K, v V) *HashTableTransient[K, V] {
+    t.set(k, v)
+    if t.LoadFactor() > 0.7 {
+        t.Resize(t.h.nslots * 2)
+    }
+    return t
+}
+
+
+// Halves the slots, as many times as Remove would have, in one resize.
+func (t *HashTableTransient[K, V]) shrink() {
+    h := t.table()
+    nslots := h.nslots
+    for nslots > 1 && float32(h.nentries) / float32(nslots) < 0.3 {
+        nslots /= 2
+    }
+    if nslots != h.nslots {
+        t.Resize(nslots)
+    }
+}
+
+
+// The set operations below are built with a transient of a, so that a's
+// buckets are shared by the result unless they are changed.
+
+
+// Keys in both tables take the value resolve returns.
+func (a *HashTable[K, V]) Merge(b *HashTable[K, V],
+                                resolve func(k K, va, vb V) V) *HashTable[K, V] {
+    t := a.Transient()
+    for k, vb := range b.All() {
+        if va, ok := a.Get(k); ok {
+            v := resolve(k, va, vb)
+            if v != va {
+                t.Set(k, v)
+            }
+        } else {
+            t.Set(k, vb)
+        }
+    }
+    return t.Persistent()
+}
+
+
+func (h *HashTable[K, V]) Filter(f func(k K, v V) bool) *HashTable[K, V] {
+    t := h.Transient()
+    for k, v := range h.All() {
+        if !f(k, v) {
+            t.remove(k)
+        }
+    }
+    t.shrink()
+    return t.Persistent()
+}
+
+
+// Entries of a whose keys are in b.
+func (a *HashTable[K, V]) Intersect(b *HashTable[K, V]) *HashTable[K, V] {
+    return a.Filter(func(k K, _ V) bool {
+                        _, ok := b.Get(k)
+                        return ok
+                    })
+}
+
+
+// Entries of a whose keys aren't in b.
+func (a *HashTable[K, V]) Difference(b *HashTable[K, V]) *HashTable[K, V] {
+    return a.Filter(func(k K, _ V) bool {
+                        _, ok := b.Get(k)
+                        return !ok
+                    })
+}
+
+
+// Buckets shared between the two tables are skipped without comparing their
+// entries.
+func (a *HashTable[K, V]) Equal(b *HashTable[K, V]) bool {
+    if a == b {
+        return true
+    }
+    if a.nentries != b.nentries {
+        return false
+    }
+    for i, _ := range a.slots {
+        if a.sharedslot(b, i) {
+            continue
+        }
+        for _, e := range a.slots[i] {
+            if v, ok := b.Get(e.k); !ok || v != e.v {
+                return false
+            }
+        }
+    }
+    return true
+}
+
+
+// Whether slot i of a is also slot i of b, which is the case for the slots
+// an update didn't touch.
+func (a *HashTable[K, V]) sharedslot(b *HashTable[K, V], i int) bool {
+    return a.nslots == b.nslots &&
+           len(a.slots[i]) == len(b.slots[i]) &&
+           (len(a.slots[i]) == 0 || &a.slots[i][0] == &b.slots[i][0])
+}
+
+
+// Keys that are in only one of the tables or have different values in each.
+func (a *HashTable[K, V]) Diff(b *HashTable[K, V]) []K {
+    keys := make([]K, 0)
+    if a == b {
+        return keys
+    }
+    for i, _ := range a.slots {
+        if a.sharedslot(b, i) {
+            continue
+        }
+        for _, e := range a.slots[i] {
+            if v, ok := b.Get(e.k); !ok || v != e.v {
+                keys = append(keys, e.k)
+            }
+        }
+    }
+    for i, _ := range b.slots {
+        if b.sharedslot(a, i) {
+            continue
+        }
+        for _, e := range b.slots[i] {
+            if _, ok := a.Get(e.k); !ok {
+                keys = append(keys, e.k)
+            }
+        }
+    }
+    return keys
+}
+
+
+// Records each version of a table as it is updated, numbered from 0, so
+// that earlier versions can be read and compared.  Versions share the slots
+// they have in common, so keeping many costs little more than the changes
+// between them.  Versions older than the newest retain are dropped.
+type HashTableHistory[K, V comparable] struct {
+    versions []*HashTable[K, V]
+    first int
+    retain int
+}
+
+
+// A retain of 0 keeps every version.
+func HashTableHistoryNew[K, V comparable](h *HashTable[K, V],
+                                          retain int) *HashTableHistory[K, V] {
+    hh := new(HashTableHistory[K, V])
+    hh.versions = []*HashTable[K, V]{ h }
+    hh.retain = retain
+    return hh
+}
+
+
+func (hh *HashTableHistory[K, V]) Version() int {
+    return hh.first + len(hh.versions) - 1
+}
+
+
+func (hh *HashTableHistory[K, V]) Current() *HashTable[K, V] {
+    return hh.versions[len(hh.versions) - 1]
+}
+
+
+// The oldest version still held.
+func (hh *HashTableHistory[K, V]) Oldest() int {
+    return hh.first
+}
+
+
+func (hh *HashTableHistory[K, V]) At(version int) (*HashTable[K, V], bool) {
+    if version < hh.first || version > hh.Version() {
+        return nil, false
+    }
+    return hh.versions[version - hh.first], true
+}
+
+
+// Records h as the next version and returns its number.
+func (hh *HashTableHistory[K, V]) Commit(h *HashTable[K, V]) int {
+    hh.versions = append(hh.versions, h)
+    if hh.retain > 0 && len(hh.versions) > hh.retain {
+        hh.Prune(hh.Version() - hh.retain + 1)
+    }
+    return hh.Version()
+}
+
+
+func (hh *HashTableHistory[K, V]) Set(k K, v V) int {
+    return hh.Commit(hh.Current().Set(k, v))
+}
+
+
+func (hh *HashTableHistory[K, V]) Remove(k K) int {
+    return hh.Commit(hh.Current().Remove(k))
+}
+
+
+// Drops the versions older than version, so that the slots only they use
+// can be garbage collected.
+func (hh *HashTableHistory[K, V]) Prune(version int) {
+    version = min(version, hh.Version())
+    if version <= hh.first {
+        return
+    }
+    versions := make([]*HashTable[K, V], hh.Version() - version + 1)
+    copy(versions, hh.versions[version - hh.first:])
+    hh.versions = versions
+    hh.first = version
+}
+
+
+func (hh *HashTableHistory[K, V]) Diff(v1, v2 int) ([]K, bool) {
+    h1, ok1 := hh.At(v1)
+    h2, ok2 := hh.At(v2)
+    if !ok1 || !ok2 {
+        return nil, false
+    }
+    return h1.Diff(h2), true
+}
+
+
+func MapValues[K, V, W comparable](h *HashTable[K, V],
+                                   f func(k K, v V) W) *HashTable[K, W] {
+    t := HashTableNew[K, W](h.nslots, h.hash).Transient()
+    for k, v := range h.All() {
+        t.set(k, f(k, v))
+    }
+    return t.Persistent()
+}
+
+
+// Holds the current version of a HashTable for concurrent use.  Readers take
+// a snapshot with a single atomic load and are never blocked; writers build
+// a new version from the snapshot they read and publish it with a
+// compare-and-swap, retrying if another writer got there first.
+type HashTableAtomic[K, V comparable] struct {
+    root atomic.Pointer[HashTable[K, V]]
+}
+
+
+func HashTableAtomicNew[K, V comparable](h *HashTable[K, V]) *HashTableAtomic[K, V] {
+    a := new(HashTableAtomic[K, V])
+    a.root.Store(h)
+    return a
+}
+
+
+func (a *HashTableAtomic[K, V]) Load() *HashTable[K, V] {
+    return a.root.Load()
+}
+
+
+func (a *HashTableAtomic[K, V]) Get(k K) (V, bool) {
+    return a.Load().Get(k)
+}
+
+
+// f may be called more than once if there is contention, so it should only
+// derive the new version from the one it is given.
+func (a *HashTableAtomic[K, V]) Update(f func(h *HashTable[K, V]) *HashTable[K, V]) *HashTable[K, V] {
+    for {
+        h := a.root.Load()
+        h2 := f(h)
+        if h2 == h || a.root.CompareAndSwap(h, h2) {
+            return h2
+        }
+    }
+}
+
+
+// Applies a batch of changes through a transient and publishes them as a
+// single new version.
+func (a *HashTableAtomic[K, V]) Batch(f func(t *HashTableTransient[K, V])) *HashTable[K, V] {
+    return a.Update(func(h *HashTable[K, V]) *HashTable[K, V] {
+                        t := h.Transient()
+                        f(t)
+                        return t.Persistent()
+                    })
+}
+
+
+func (a *HashTableAtomic[K, V]) Set(k K, v V) *HashTable[K, V] {
+    return a.Update(func(h *HashTable[K, V]) *HashTable[K, V] {
+                        return h.Set(k, v)
+                    })
+}
+
+
+func (a *HashTableAtomic[K, V]) Remove(k K) *HashTable[K, V] {
+    return a.Update(func(h *HashTable[K, V]) *HashTable[K, V] {
+                        return h.Remove(k)
+                    })
+}
+
+
+// The number of slots a table reaches by doubling from 1 as n entries are set.
+func hashtableslotsfor(n int) int {
+    nslots := 1
+    for float32(n) / float32(nslots) > 0.7 {
+        nslots *= 2
+    }
+    return nslots
+}
+
+
+// Rebuilds h in place from keys and values.  h must be newly created and not
+// yet shared, as this is the only place a HashTable is mutated after
+// creation.
+func (h *HashTable[K, V]) hashtableload(nslots int, keys []K, values []V) {
+    if len(keys) != len(values) {
+        panic("hashtableload: keys and values differ in length")
+    }
+    t := HashTableNew[K, V](nslots, h.hash).Transient()
+    for i, k := range keys {
+        t.set(k, values[i])
+    }
+    *h = *t.Persistent()
+}
+
+
+type HashTableJSONEntry[K, V comparable] struct {
+    K K `json:"k"`
+    V V `json:"v"`
+}
+
+
+// Tables with string keys are marshalled as a JSON object, others as an
+// array of {"k": ..., "v": ...} entries.
+func (h *HashTable[K, V]) MarshalJSON() ([]byte, error) {
+    if reflect.TypeFor[K]().Kind() == reflect.String {
+        m := make(map[string]V, h.nentries)
+        for k, v := range h.All() {
+            m[reflect.ValueOf(k).String()] = v
+        }
+        return json.Marshal(m)
+    }
+    l := make([]HashTableJSONEntry[K, V], 0, h.nentries)
+    for k, v := range h.All() {
+        l = append(l, HashTableJSONEntry[K, V]{ k, v })
+    }
+    return json.Marshal(l)
+}
+
+
+// h must have been created by HashTableNew, to supply the hash function, and
+// not yet shared; a table without one, such as a nil field that
+// json.Unmarshal allocates, is an error.  Either of the forms written by
+// MarshalJSON is accepted.
+func (h *HashTable[K, V]) UnmarshalJSON(data []byte) error {
+    if h.hash == nil {
+        return errors.New("HashTable: no hash function, so not created by HashTableNew")
+    }
+    keys := make([]K, 0)
+    values := make([]V, 0)
+    data = bytes.TrimSpace(data)
+    if len(data) > 0 && data[0] == '{' {
+        if reflect.TypeFor[K]().Kind() != reflect.String {
+            return errors.New("HashTable: JSON object needs string keys")
+        }
+        m := make(map[string]V)
+        if err := json.Unmarshal(data, &m); err != nil {
+            return err
+        }
+        for s, v := range m {
+            k := reflect.ValueOf(s).Convert(reflect.TypeFor[K]()).Interface()
+            keys = append(keys, k.(K))
+            values = append(values, v)
+        }
+    } else {
+        l := make([]HashTableJSONEntry[K, V], 0)
+        if err := json.Unmarshal(data, &l); err != nil {
+            return err
+        }
+        for _, e := range l {
+            keys = append(keys, e.K)
+            values = append(values, e.V)
+        }
+    }
+    h.hashtableload(hashtableslotsfor(len(keys)), keys, values)
+    return nil
+}
+
+
+// A snapshot is a fixed header, a gob-encoded payload holding the keys and
+// values, and a CRC-32 of everything before it:
+//
+//     magic "HTBL" | version uint16 | nentries uint64 | nslots uint64 |
+//     payload length uint64 | payload | crc32 uint32
+//
+// All integers are big-endian.
+const hashtablesnapshotmagic = "HTBL"
+const hashtablesnapshotversion = 1
+
+
+type HashTableSnapshotHeader struct {
+    Magic [4]byte
+    Version uint16
+    NEntries uint64
+    NSlots uint64
+    NPayload uint64
+}
+
+
+type HashTableSnapshotPayload[K, V comparable] struct {
+    Keys []K
+    Values []V
+}
+
+
+func (h *HashTable[K, V]) WriteTo(w io.Writer) (int64, error) {
+    var payload HashTableSnapshotPayload[K, V]
+    payload.Keys = make([]K, 0, h.nentries)
+    payload.Values = make([]V, 0, h.nentries)
+    for k, v := range h.All() {
+        payload.Keys = append(payload.Keys, k)
+        payload.Values = append(payload.Values, v)
+    }
+    var p bytes.Buffer
+    if err := gob.NewEncoder(&p).Encode(payload); err != nil {
+        return 0, err
+    }
+
+    var b bytes.Buffer
+    header := HashTableSnapshotHeader{ [4]byte([]byte(hashtablesnapshotmagic)),
+                                       hashtablesnapshotversion,
+                                       uint64(h.nentries),
+                                       uint64(h.nslots),
+                                       uint64(p.Len()) }
+    binary.Write(&b, binary.BigEndian, header)
+    b.Write(p.Bytes())
+    binary.Write(&b, binary.BigEndian, crc32.ChecksumIEEE(b.Bytes()))
+    return b.WriteTo(w)
+}
+
+
+func HashTableReadFrom[K, V comparable](r io.Reader,
+                                        hash func(k K, m int) int) (*HashTable[K, V], error) {
+    var header HashTableSnapshotHeader
+    if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+        return nil, err
+    }
+    if string(header.Magic[:]) != hashtablesnapshotmagic {
+        return nil, errors.New("HashTable: not a snapshot")
+    }
+    if header.Version != hashtablesnapshotversion {
+        return nil, fmt.Errorf("HashTable: unsupported snapshot version %v",
+                               header.Version)
+    }
+    if header.NSlots < 1 || header.NSlots > math.MaxInt32 {
+        return nil, fmt.Errorf("HashTable: bad snapshot slot count %v",
+                               header.NSlots)
+    }
+
+    var b bytes.Buffer
+    binary.Write(&b, binary.BigEndian, header)
+    if _, err := io.CopyN(&b, r, int64(header.NPayload)); err != nil {
+        return nil, err
+    }
+    var checksum uint32
+    if err := binary.Read(r, binary.BigEndian, &checksum); err != nil {
+        return nil, err
+    }
+    if checksum != crc32.ChecksumIEEE(b.Bytes()) {
+        return nil, errors.New("HashTable: snapshot checksum mismatch")
+    }
+
+    var payload HashTableSnapshotPayload[K, V]
+    b.Next(binary.Size(header))
+    if err := gob.NewDecoder(&b).Decode(&payload); err != nil {
+        return nil, err
+    }
+    if uint64(len(payload.Keys)) != header.NEntries ||
+       len(payload.Values) != len(payload.Keys) {
+        return nil, errors.New("HashTable: snapshot entry count mismatch")
+    }
+    h := HashTableNew[K, V](1, hash)
+    h.hashtableload(int(header.NSlots), payload.Keys, payload.Values)
+    return h, nil
+}
+
+
 func hashsumchars(s string, m int) int {
     sum := 0
     for _, e := range s {
@@ -160,6 +923,13 @@ func hashsumchars(s string, m int) int {
 }
 
 
+func hashfnv(s string, m int) int {
+    h := fnv.New32a()
+    h.Write([]byte(s))
+    return int(h.Sum32() % uint32(m))
+}
+
+
 func main() {
     elements := []string{ "hydrogen",
                           "helium",
@@ -193,8 +963,10 @@ func main() {
     v, ok := hashtable.Get("oxygen")
     fmt.Printf("%v %v\n", v, ok)
 
+    fmt.Printf("%+v\n", hashtable.Stats())
 
-    for _, e := range hashtable.Keys() {
+
+    for _, e := range SortedKeys(hashtable) {
         hashtable = hashtable.Remove(e)
         fmt.Println(hashtable)
     }
@@ -227,5 +999,142 @@ func main() {
     fmt.Printf("%p %v\n", h8, h8)
 
 
+    t := h4.Transient()
+    for _, e := range elements {
+        t = t.Set(e, len(e))
+    }
+    t = t.Remove("tom")
+    h9 := t.Persistent()
+    fmt.Printf("%p %v\n", h4, h4)
+    fmt.Printf("%p %v\n", h9, h9)
+
+    t1 := HashTableNew[string, int](1, hashsumchars).Transient().Remove("tom")
+    fmt.Printf("%v\n", t1.Set("tom", 1).Persistent())
+    // => &{1 2} load 0.5
+
+    h1 := HashTableNew[string, int](1, hashsumchars).Remove("tom").Set("tom", 1)
+    history1 := HashTableHistoryNew(HashTableNew[string, int](1, hashsumchars), 0)
+    history1.Remove("tom")
+    history1.Set("tom", 1)
+    a1 := HashTableAtomicNew(HashTableNew[string, int](1, hashsumchars))
+    a1.Remove("tom")
+    a1.Set("tom", 1)
+    fmt.Printf("%v %v %v\n", h1, history1.Current(), a1.Load())
+    // => &{1 2} load 0.5 &{1 2} load 0.5 &{1 2} load 0.5
+
+
+    a := HashTableAtomicNew(h)
+    snapshot := a.Load()
+    a.Set("tom", 1)
+    a.Batch(func(t *HashTableTransient[string, int]) {
+                t.Set("dick", 2)
+                t.Set("harry", 3)
+            })
+    a.Remove("tom")
+    fmt.Printf("%p %v\n", snapshot, snapshot)
+    fmt.Printf("%p %v\n", a.Load(), a.Load())
+
+
+    words := make([]string, 0, 26 * 26 * 26)
+    for _, a := range "abcdefghijklmnopqrstuvwxyz" {
+        for _, b := range "abcdefghijklmnopqrstuvwxyz" {
+            for _, c := range "abcdefghijklmnopqrstuvwxyz" {
+                words = append(words, string([]rune{ a, b, c }))
+            }
+        }
+    }
+    stats, poor := HashTablePoorHash(hashsumchars, words, 1024)
+    fmt.Printf("%v %v %v %v\n", poor, stats.maxchain, stats.empty, stats.uniformity)
+    stats, poor = HashTablePoorHash(hashfnv, words, 1024)
+    fmt.Printf("%v %v %v %v\n", poor, stats.maxchain, stats.empty, stats.uniformity)
+
+
+    data, err := json.Marshal(h9)
+    fmt.Printf("%s %v\n", data, err)
+    h10 := HashTableNew[string, int](1, hashsumchars)
+    err = json.Unmarshal(data, h10)
+    fmt.Printf("%v %v\n", h10, err)
+    // => &{22 32} load 0.6875 <nil>
+
+    var wrapped struct { T *HashTable[string, int] }
+    err = json.Unmarshal([]byte(`{"T":{"a":1}}`), &wrapped)
+    fmt.Printf("%v\n", err)
+    // => HashTable: no hash function, so not created by HashTableNew
+
+    squares := HashTableNew[int, int](1, func(k int, m int) int { return k % m })
+    for i := 0; i < 5; i++ {
+        squares = squares.Set(i, i * i)
+    }
+    data, err = json.Marshal(squares)
+    fmt.Printf("%s %v\n", data, err)
+    // => [{"k":0,"v":0},{"k":1,"v":1},{"k":2,"v":4},{"k":3,"v":9},{"k":4,"v":16}] <nil>
+
+    var b bytes.Buffer
+    n, err := h9.WriteTo(&b)
+    fmt.Printf("%v %v\n", n, err)
+    h11, err := HashTableReadFrom[string, int](&b, hashsumchars)
+    fmt.Printf("%v %v\n", h11, err)
+    // => &{22 64} load 0.34375 <nil>
+    v, ok = h11.Get("oxygen")
+    fmt.Printf("%v %v\n", v, ok)
+    // => 6 true
+
+
+    nobles := HashTableNew[string, int](1, hashsumchars).
+                  Set("helium", 0).
+                  Set("neon", 0).
+                  Set("argon", 0).
+                  Set("krypton", 0)
+    merged := h9.Merge(nobles,
+                       func(k string, va, vb int) int {
+                           return va + vb
+                       })
+    fmt.Printf("%v %v\n", merged, SortedKeys(merged.Difference(h9)))
+    // => &{23 64} load 0.359375 [krypton]
+    fmt.Printf("%v\n", SortedKeys(h9.Intersect(nobles)))
+    // => [argon helium neon]
+    fmt.Printf("%v\n", SortedKeys(h9.Filter(func(k string, v int) bool {
+                                                 return v > 8
+                                             })))
+    // => [aluminium beryllium magnesium phosphorus potassium]
+    fmt.Printf("%v %v %v\n",
+               merged.Equal(h9),
+               merged.Remove("krypton").Equal(h9),
+               h9.Equal(h11))
+    // => false true true
+    lengths := MapValues(h9, func(k string, v int) string {
+                                 return fmt.Sprintf("%v=%v", k, v)
+                             })
+    v2, ok := lengths.Get("oxygen")
+    fmt.Printf("%v %v\n", v2, ok)
+    // => oxygen=6 true
+
+
+    history := HashTableHistoryNew(HashTableNew[string, int](1, hashsumchars), 4)
+    history.Set("tom", 1)
+    history.Set("dick", 2)
+    history.Set("harry", 3)
+    history.Set("harry", 4)
+    fmt.Printf("%v %v %v\n",
+               history.Oldest(),
+               history.Version(),
+               SortedKeys(history.Current()))
+    // => 1 4 [dick harry tom]
+    history.Remove("tom")
+    h12, ok := history.At(2)
+    v, _ = h12.Get("tom")
+    fmt.Printf("%v %v %v\n", history.Oldest(), v, ok)
+    // => 2 1 true
+    _, ok = history.At(1)
+    fmt.Printf("%v\n", ok)
+    // => false
+    changed, ok := history.Diff(2, 5)
+    fmt.Printf("%v %v\n", QuickSort(Compare[string], changed), ok)
+    // => [harry tom] true
+    history.Prune(5)
+    fmt.Printf("%v %v\n", history.Oldest(), history.Version())
+    // => 5 5
+
+
     os.Exit(0)
 }
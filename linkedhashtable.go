@@ -0,0 +1,245 @@
+package main
+
+
+// host$ go build linkedhashtable.go
+// host$ ./linkedhashtable
+
+
+import "fmt"
+import "iter"
+import "os"
+
+
+// A chaining hash table whose entries are also threaded on a doubly linked
+// list in insertion order, so that iteration order doesn't depend on the
+// number of slots.  Setting an existing key keeps its original position.
+
+
+type LinkedHashTableEntry[K, V comparable] struct {
+    k K
+    v V
+    prev *LinkedHashTableEntry[K, V]
+    next *LinkedHashTableEntry[K, V]
+}
+
+
+type LinkedHashTable[K, V comparable] struct {
+    nentries int
+    nslots int
+    slots [][]*LinkedHashTableEntry[K, V]
+    hash func(k K, m int) int
+    first *LinkedHashTableEntry[K, V]
+    last *LinkedHashTableEntry[K, V]
+}
+
+
+func LinkedHashTableNew[K, V comparable](nslots int,
+                                         hash func(k K, m int) int) *LinkedHashTable[K, V] {
+    h := new(LinkedHashTable[K, V])
+    h.nentries = 0
+    h.nslots = nslots
+    h.slots = make([][]*LinkedHashTableEntry[K, V], nslots)
+    for i := range h.slots {
+        h.slots[i] = make([]*LinkedHashTableEntry[K, V], 0)
+    }
+    h.hash = hash
+    return h
+}
+
+
+func (h *LinkedHashTable[K, V]) String() string {
+    s := "["
+    for k, v := range h.All() {
+        if len(s) > 1 {
+            s += " "
+        }
+        s += fmt.Sprintf("%v:%v", k, v)
+    }
+    return fmt.Sprintf("%v] load %v", s, h.LoadFactor())
+}
+
+
+func (h *LinkedHashTable[K, V]) Get(k K) (V, bool) {
+    slot := h.hash(k, h.nslots)
+    for _, e := range h.slots[slot] {
+        if e.k == k {
+            return e.v, true
+        }
+    }
+    return *new(V), false
+}
+
+
+func (h *LinkedHashTable[K, V]) All() iter.Seq2[K, V] {
+    return func(yield func(k K, v V) bool) {
+        for e := h.first; e != nil; e = e.next {
+            if !yield(e.k, e.v) {
+                return
+            }
+        }
+    }
+}
+
+
+func (h *LinkedHashTable[K, V]) Keys() iter.Seq[K] {
+    return func(yield func(k K) bool) {
+        for k, _ := range h.All() {
+            if !yield(k) {
+                return
+            }
+        }
+    }
+}
+
+
+func (h *LinkedHashTable[K, V]) Values() iter.Seq[V] {
+    return func(yield func(v V) bool) {
+        for _, v := range h.All() {
+            if !yield(v) {
+                return
+            }
+        }
+    }
+}
+
+
+func (h *LinkedHashTable[K, V]) LoadFactor() float32 {
+    return float32(h.nentries) / float32(h.nslots)
+}
+
+
+func remove[T any](v []T, i int) []T {
+    v[i] = v[len(v) - 1]
+    v = v[:len(v) - 1]
+    return v
+}
+
+
+func (h *LinkedHashTable[K, V]) unlink(e *LinkedHashTableEntry[K, V]) {
+    if e.prev == nil {
+        h.first = e.next
+    } else {
+        e.prev.next = e.next
+    }
+    if e.next == nil {
+        h.last = e.prev
+    } else {
+        e.next.prev = e.prev
+    }
+}
+
+
+func (h *LinkedHashTable[K, V]) Remove(k K) {
+    slot := h.hash(k, h.nslots)
+    for i, e := range h.slots[slot] {
+        if e.k == k {
+            h.slots[slot] = remove(h.slots[slot], i)
+            h.unlink(e)
+            h.nentries--
+            break
+        }
+    }
+    if h.LoadFactor() < 0.3 && h.nslots > 1 {
+        h.Resize(h.nslots / 2)
+    }
+}
+
+
+// Only the slots are rebuilt; the entries and their order are unchanged.
+func (h *LinkedHashTable[K, V]) Resize(nslots int) {
+    h.nslots = nslots
+    h.slots = make([][]*LinkedHashTableEntry[K, V], nslots)
+    for i := range h.slots {
+        h.slots[i] = make([]*LinkedHashTableEntry[K, V], 0)
+    }
+    for e := h.first; e != nil; e = e.next {
+        slot := h.hash(e.k, h.nslots)
+        h.slots[slot] = append(h.slots[slot], e)
+    }
+}
+
+
+func (h *LinkedHashTable[K, V]) Set(k K, v V) {
+    slot := h.hash(k, h.nslots)
+    for _, e := range h.slots[slot] {
+        if e.k == k {
+            e.v = v
+            return
+        }
+    }
+    e := &LinkedHashTableEntry[K, V]{ k, v, h.last, nil }
+    if h.last == nil {
+        h.first = e
+    } else {
+        h.last.next = e
+    }
+    h.last = e
+    h.slots[slot] = append(h.slots[slot], e)
+    h.nentries++
+    if h.LoadFactor() > 0.7 {
+        h.Resize(h.nslots * 2)
+    }
+}
+
+
+func hashsumchars(s string, m int) int {
+    sum := 0
+    for _, e := range s {
+        sum += int(e)
+    }
+    return sum % m
+}
+
+
+func main() {
+    elements := []string{ "hydrogen",
+                          "helium",
+                          "lithium",
+                          "beryllium",
+                          "boron",
+                          "carbon",
+                          "nitrogen",
+                          "oxygen",
+                          "fluorine",
+                          "neon" }
+    hashtable := LinkedHashTableNew[string, int](1, hashsumchars)
+    fmt.Println(hashtable)
+    // => [] load 0
+
+
+    for _, e := range elements {
+        hashtable.Set(e, len(e))
+        fmt.Println(hashtable)
+    }
+    // => [hydrogen:8 helium:6 lithium:7 ... neon:4] load 0.625
+
+    hashtable.Set("hydrogen", 1)
+    hashtable.Remove("lithium")
+    fmt.Println(hashtable)
+    // => [hydrogen:1 helium:6 beryllium:9 ... neon:4] load 0.5625
+
+    v, ok := hashtable.Get("oxygen")
+    fmt.Printf("%v %v\n", v, ok)
+    // => 6 true
+
+
+    sum := 0
+    for v := range hashtable.Values() {
+        sum += v
+    }
+    fmt.Printf("%v\n", sum)
+    // => 53
+
+
+    for e := range hashtable.Keys() {
+        hashtable.Remove(e)
+        fmt.Println(hashtable)
+    }
+
+    v, ok = hashtable.Get("oxygen")
+    fmt.Printf("%v %v\n", v, ok)
+    // => 0 false
+
+
+    os.Exit(0)
+}
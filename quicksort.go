@@ -3,10 +3,22 @@ package main
 
 // host$ go build quicksort.go
 // host$ ./quicksort
+// host$ ./quicksort bench
+// host$ go run -race quicksort.go
 
 
 import "fmt"
+import "iter"
+import "math"
+import "math/bits"
+import "math/rand"
 import "os"
+import "reflect"
+import "runtime"
+import "slices"
+import "sync"
+import "testing"
+import "unsafe"
 
 
 type Ordered interface {
@@ -16,6 +28,18 @@ type Ordered interface {
 }
 
 
+type Integer interface {
+    ~int | ~int8 | ~int16 | ~int32 | ~int64 |
+    ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 |
+    ~uintptr
+}
+
+
+type Float interface {
+    ~float32 | ~float64
+}
+
+
 func Compare[A Ordered](x, y A) int {
     booltoint := func (b bool) int {
         if b {
@@ -29,28 +53,939 @@ func Compare[A Ordered](x, y A) int {
 }
 
 
-func QuickSort[A Ordered](compare func (x, y A) int, l []A) []A {
-    if len(l) == 0 || len(l) == 1 {
-        return l
+// Introsort: quicksort in place, with insertion sort for short ranges and a
+// switch to heapsort if the recursion gets deeper than 2 log n, which bounds
+// the worst case at O(n log n).  l is sorted in place and returned.
+func QuickSort[A any](compare func (x, y A) int, l []A) []A {
+    quicksort(compare, l, 2 * bits.Len(uint(len(l))))
+    return l
+}
+
+
+const quicksortinsertion = 12
+const quicksortninther = 40
+
+
+// Recurses into the shorter side of each partition and loops on the longer,
+// so the stack is at most log n deep.
+func quicksort[A any](compare func (x, y A) int, l []A, depth int) {
+    for len(l) > quicksortinsertion {
+        if depth == 0 {
+            HeapSort(compare, l)
+            return
+        }
+        depth--
+        p := partition(compare, l)
+        if p < len(l) - p {
+            quicksort(compare, l[:p], depth)
+            l = l[p + 1:]
+        } else {
+            quicksort(compare, l[p + 1:], depth)
+            l = l[:p]
+        }
+    }
+    InsertionSort(compare, l)
+}
+
+
+func median3[A any](compare func (x, y A) int, l []A, i, j, k int) int {
+    if compare(l[i], l[j]) > 0 {
+        i, j = j, i
+    }
+    if compare(l[j], l[k]) <= 0 {
+        return j
+    }
+    if compare(l[i], l[k]) > 0 {
+        return i
+    }
+    return k
+}
+
+
+// Median of three, or for longer ranges Tukey's ninther, the median of the
+// medians of three groups of three.
+func choosepivot[A any](compare func (x, y A) int, l []A) int {
+    n := len(l)
+    i, j, k := 0, n / 2, n - 1
+    if n >= quicksortninther {
+        d := n / 8
+        i = median3(compare, l, i, i + d, i + 2 * d)
+        j = median3(compare, l, j - d, j, j + d)
+        k = median3(compare, l, k - 2 * d, k - d, k)
     }
+    return median3(compare, l, i, j, k)
+}
+
 
+// Partitions l around the chosen pivot and returns the pivot's index, with
+// no greater elements before it and no lesser ones after.  Both scans stop
+// at elements equal to the pivot, so runs of equal elements are split
+// evenly instead of degrading to O(n^2).
+func partition[A any](compare func (x, y A) int, l []A) int {
+    m := choosepivot(compare, l)
+    l[0], l[m] = l[m], l[0]
     pivot := l[0]
-    left := make([]A, 0, len(l) / 2)
-    right := make([]A, 0, len(l) / 2)
+    i, j := 1, len(l) - 1
+    for {
+        for i <= j && compare(l[i], pivot) < 0 {
+            i++
+        }
+        for i <= j && compare(l[j], pivot) > 0 {
+            j--
+        }
+        if i >= j {
+            break
+        }
+        l[i], l[j] = l[j], l[i]
+        i++
+        j--
+    }
+    l[0], l[j] = l[j], l[0]
+    return j
+}
+
+
+func InsertionSort[A any](compare func (x, y A) int, l []A) []A {
+    for i := 1; i < len(l); i++ {
+        for j := i; j > 0 && compare(l[j], l[j - 1]) < 0; j-- {
+            l[j], l[j - 1] = l[j - 1], l[j]
+        }
+    }
+    return l
+}
+
+
+func siftdown[A any](compare func (x, y A) int, l []A, i int) {
+    for {
+        child := 2 * i + 1
+        if child >= len(l) {
+            return
+        }
+        if child + 1 < len(l) && compare(l[child], l[child + 1]) < 0 {
+            child++
+        }
+        if compare(l[i], l[child]) >= 0 {
+            return
+        }
+        l[i], l[child] = l[child], l[i]
+        i = child
+    }
+}
+
+
+func HeapSort[A any](compare func (x, y A) int, l []A) []A {
+    for i := len(l) / 2 - 1; i >= 0; i-- {
+        siftdown(compare, l, i)
+    }
+    for i := len(l) - 1; i > 0; i-- {
+        l[0], l[i] = l[i], l[0]
+        siftdown(compare, l[:i], 0)
+    }
+    return l
+}
+
+
+// Rearranges l so that l[k] is the element that would be there if l were
+// sorted, with no greater elements before it and no lesser ones after, and
+// returns l.  Quickselect: QuickSort's partitioning, but only following the
+// side k is on, so O(n) expected.  Like QuickSort, falls back to heapsort
+// on what's left if that takes more than 2 log n partitions.
+func NthElement[A any](compare func (x, y A) int, l []A, k int) []A {
+    if k < 0 || k >= len(l) {
+        panic(fmt.Sprintf("NthElement: index %v out of range [0:%v]", k, len(l)))
+    }
+    l1 := l
+    for depth := 2 * bits.Len(uint(len(l))); len(l1) > quicksortinsertion; depth-- {
+        if depth == 0 {
+            HeapSort(compare, l1)
+            return l
+        }
+        p := partition(compare, l1)
+        if k == p {
+            return l
+        } else if k < p {
+            l1 = l1[:p]
+        } else {
+            l1 = l1[p + 1:]
+            k -= p + 1
+        }
+    }
+    InsertionSort(compare, l1)
+    return l
+}
+
+
+// The element that would be at l[k] if l were sorted.  Rearranges l as
+// NthElement does.
+func Select[A any](compare func (x, y A) int, l []A, k int) A {
+    return NthElement(compare, l, k)[k]
+}
+
+
+// Sorts just the k least elements of l into l[:k], leaving the rest after
+// them in no particular order, and returns l.
+func PartialSort[A any](compare func (x, y A) int, l []A, k int) []A {
+    if k >= len(l) {
+        return QuickSort(compare, l)
+    }
+    if k > 0 {
+        NthElement(compare, l, k - 1)
+        QuickSort(compare, l[:k - 1])
+    }
+    return l
+}
+
+
+// The k least elements of seq, in order, keeping only those k in memory.
+// They're held in a heap with the greatest at the root, which each new
+// element either replaces or is discarded.  Of equal elements, the earlier
+// ones are kept, though not necessarily in their original order.
+func TopK[A any](compare func (x, y A) int, seq iter.Seq[A], k int) []A {
+    heap := make([]A, 0, max(k, 0))
+    if k <= 0 {
+        return heap
+    }
+    for e := range seq {
+        if len(heap) < k {
+            heap = append(heap, e)
+            for i := len(heap) - 1; i > 0; {
+                parent := (i - 1) / 2
+                if compare(heap[parent], heap[i]) >= 0 {
+                    break
+                }
+                heap[parent], heap[i] = heap[i], heap[parent]
+                i = parent
+            }
+        } else if compare(e, heap[0]) < 0 {
+            heap[0] = e
+            siftdown(compare, heap, 0)
+        }
+    }
+    for i := len(heap) - 1; i > 0; i-- {
+        heap[0], heap[i] = heap[i], heap[0]
+        siftdown(compare, heap[:i], 0)
+    }
+    return heap
+}
+
+
+// Compares by a key derived from each element.
+func By[A any, K Ordered](key func(a A) K) func (x, y A) int {
+    return func(x, y A) int {
+        return Compare(key(x), key(y))
+    }
+}
+
+
+// Compares with compare, falling back to then for elements it finds equal.
+func ThenBy[A any](compare func (x, y A) int,
+                   then func (x, y A) int) func (x, y A) int {
+    return func(x, y A) int {
+        if c := compare(x, y); c != 0 {
+            return c
+        }
+        return then(x, y)
+    }
+}
+
+
+func Reverse[A any](compare func (x, y A) int) func (x, y A) int {
+    return func(x, y A) int {
+        return compare(y, x)
+    }
+}
+
+
+// Compares pointers, nil first, by compare on what they point to.
+func NullsFirst[A any](compare func (x, y A) int) func (x, y *A) int {
+    return func(x, y *A) int {
+        switch {
+            case x == nil && y == nil:
+                return 0
+            case x == nil:
+                return -1
+            case y == nil:
+                return 1
+            default:
+                return compare(*x, *y)
+        }
+    }
+}
+
+
+// Stable, top-down, with one buffer half the length of l.
+func MergeSort[A any](compare func (x, y A) int, l []A) []A {
+    mergesort(compare, l, make([]A, 0, len(l) / 2 + 1))
+    return l
+}
+
+
+func mergesort[A any](compare func (x, y A) int, l []A, buf []A) {
+    if len(l) <= quicksortinsertion {
+        InsertionSort(compare, l)
+        return
+    }
+    mid := len(l) / 2
+    mergesort(compare, l[:mid], buf)
+    mergesort(compare, l[mid:], buf)
+    if compare(l[mid - 1], l[mid]) > 0 {
+        merge(compare, l, mid, buf)
+    }
+}
+
+
+// Merges the sorted l[:mid] and l[mid:], copying the first into buf.  Ties
+// go to the first, which keeps the merge stable.
+func merge[A any](compare func (x, y A) int, l []A, mid int, buf []A) {
+    buf = append(buf[:0], l[:mid]...)
+    i, j, k := 0, mid, 0
+    for i < len(buf) && j < len(l) {
+        if compare(l[j], buf[i]) < 0 {
+            l[k] = l[j]
+            j++
+        } else {
+            l[k] = buf[i]
+            i++
+        }
+        k++
+    }
+    copy(l[k:], buf[i:])
+}
+
+
+type TimSortRun struct {
+    start int
+    length int
+}
+
+
+// Runs shorter than this are extended with insertion sort, chosen so that
+// the number of runs is at or just below a power of two.
+func timsortminrun(n int) int {
+    r := 0
+    for n >= 64 {
+        r |= n & 1
+        n >>= 1
+    }
+    return n + r
+}
+
+
+// Stable.  Finds the ascending and strictly descending runs already in l,
+// reversing the latter, and merges them while keeping the lengths on the
+// run stack decreasing faster than the Fibonacci numbers, so that sorted or
+// nearly sorted input takes O(n).
+func TimSort[A any](compare func (x, y A) int, l []A) []A {
+    minrun := timsortminrun(len(l))
+    buf := make([]A, 0, len(l) / 2 + 1)
+    runs := make([]TimSortRun, 0)
+    for i := 0; i < len(l); {
+        j := i + 1
+        if j < len(l) {
+            if compare(l[j], l[i]) < 0 {
+                for j + 1 < len(l) && compare(l[j + 1], l[j]) < 0 {
+                    j++
+                }
+                j++
+                slices.Reverse(l[i:j])
+            } else {
+                for j + 1 < len(l) && compare(l[j + 1], l[j]) >= 0 {
+                    j++
+                }
+                j++
+            }
+        }
+        if j - i < minrun {
+            end := min(i + minrun, len(l))
+            for k := j; k < end; k++ {
+                for m := k; m > i && compare(l[m], l[m - 1]) < 0; m-- {
+                    l[m], l[m - 1] = l[m - 1], l[m]
+                }
+            }
+            j = end
+        }
+        runs = append(runs, TimSortRun{ i, j - i })
+        runs = timsortcollapse(compare, l, runs, buf, false)
+        i = j
+    }
+    timsortcollapse(compare, l, runs, buf, true)
+    return l
+}
+
+
+func timsortmergeat[A any](compare func (x, y A) int,
+                           l []A,
+                           runs []TimSortRun,
+                           i int,
+                           buf []A) []TimSortRun {
+    a, b := runs[i], runs[i + 1]
+    merge(compare, l[a.start:b.start + b.length], a.length, buf)
+    runs[i].length += b.length
+    return append(runs[:i + 1], runs[i + 2:]...)
+}
+
+
+// Merges runs until the invariants hold, or until one run is left if force
+// is set.
+func timsortcollapse[A any](compare func (x, y A) int,
+                            l []A,
+                            runs []TimSortRun,
+                            buf []A,
+                            force bool) []TimSortRun {
+    for len(runs) > 1 {
+        n := len(runs) - 2
+        if force {
+            if n > 0 && runs[n - 1].length < runs[n + 1].length {
+                n--
+            }
+        } else if n > 0 && runs[n - 1].length <= runs[n].length + runs[n + 1].length ||
+                  n > 1 && runs[n - 2].length <= runs[n - 1].length + runs[n].length {
+            if runs[n - 1].length < runs[n + 1].length {
+                n--
+            }
+        } else if runs[n].length > runs[n + 1].length {
+            break
+        }
+        runs = timsortmergeat(compare, l, runs, n, buf)
+    }
+    return runs
+}
+
+
+// Stable, sorting by a key computed once per element.
+func SortStableBy[A any, K Ordered](key func(a A) K, l []A) []A {
+    type keyed struct {
+        k K
+        a A
+    }
+    l1 := make([]keyed, len(l))
+    for i, e := range l {
+        l1[i] = keyed{ key(e), e }
+    }
+    MergeSort(func(x, y keyed) int {
+                  return Compare(x.k, y.k)
+              },
+              l1)
+    for i, e := range l1 {
+        l[i] = e.a
+    }
+    return l
+}
+
+
+// Below this length a range is sorted on the calling goroutine; handing it
+// to another costs more than it saves.
+const parallelsortthreshold = 1 << 14
+
+
+// The number of goroutines to use for n elements: workers, or GOMAXPROCS if
+// workers <= 0, but no more than one per parallelsortthreshold elements.
+func parallelworkers(n, workers int) int {
+    if workers <= 0 {
+        workers = runtime.GOMAXPROCS(0)
+    }
+    return max(1, min(workers, n / parallelsortthreshold))
+}
+
+
+// QuickSort with the two sides of a partition sorted concurrently, using at
+// most workers goroutines including the caller.  The partitions are the
+// same as QuickSort's and are disjoint, so the result is identical to
+// QuickSort's whatever the scheduling.
+func ParallelSort[A any](compare func (x, y A) int, l []A, workers int) []A {
+    tokens := make(chan struct{}, parallelworkers(len(l), workers) - 1)
+    var wg sync.WaitGroup
+    parallelsort(compare, l, 2 * bits.Len(uint(len(l))), tokens, &wg)
+    wg.Wait()
+    return l
+}
 
-    for _, e := range l[1:] {
-        if compare(e, pivot) <= 0 {
-            left = append(left, e)
+
+// As quicksort, but the shorter side goes to a new goroutine if a token is
+// free.  Each goroutine returns its token when done.
+func parallelsort[A any](compare func (x, y A) int,
+                         l []A,
+                         depth int,
+                         tokens chan struct{},
+                         wg *sync.WaitGroup) {
+    for len(l) > parallelsortthreshold {
+        if depth == 0 {
+            HeapSort(compare, l)
+            return
+        }
+        depth--
+        // The child gets its own copy, since this loop goes on changing depth.
+        d := depth
+        p := partition(compare, l)
+        l1 := l[:p]
+        if p < len(l) - p {
+            l = l[p + 1:]
+        } else {
+            l1 = l[p + 1:]
+            l = l[:p]
+        }
+        select {
+            case tokens <- struct{}{}:
+                wg.Add(1)
+                go func() {
+                    defer wg.Done()
+                    parallelsort(compare, l1, d, tokens, wg)
+                    <-tokens
+                }()
+            default:
+                parallelsort(compare, l1, depth, tokens, wg)
+        }
+    }
+    quicksort(compare, l, depth)
+}
+
+
+// Stable.  l is split into one chunk per worker and the chunks are merge
+// sorted concurrently, then neighbouring runs are merged in pairs,
+// concurrently, until one is left.  Ties always go to the left run, so the
+// result is the same as MergeSort's.
+func ParallelMergeSort[A any](compare func (x, y A) int, l []A, workers int) []A {
+    workers = parallelworkers(len(l), workers)
+    bounds := make([]int, workers + 1)
+    for i := range bounds {
+        bounds[i] = i * len(l) / workers
+    }
+    var wg sync.WaitGroup
+    for i := 0; i < workers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            MergeSort(compare, l[bounds[i]:bounds[i + 1]])
+        }()
+    }
+    wg.Wait()
+    for len(bounds) > 2 {
+        next := make([]int, 0, len(bounds) / 2 + 2)
+        for i := 0; i + 2 < len(bounds); i += 2 {
+            lo, mid, hi := bounds[i], bounds[i + 1], bounds[i + 2]
+            wg.Add(1)
+            go func() {
+                defer wg.Done()
+                if compare(l[mid - 1], l[mid]) > 0 {
+                    merge(compare, l[lo:hi], mid - lo, make([]A, 0, mid - lo))
+                }
+            }()
+            next = append(next, lo)
+        }
+        if len(bounds) % 2 == 0 {
+            next = append(next, bounds[len(bounds) - 2])
+        }
+        bounds = append(next, len(l))
+        wg.Wait()
+    }
+    return l
+}
+
+
+// Maps an integer to a uint64 with the same order: unsigned values are
+// zero extended, and signed ones are sign extended and have the sign bit
+// flipped, so that negative values come first.
+func radixkey[A Integer](a A) uint64 {
+    if ^A(0) < 0 {
+        return uint64(a) ^ (1 << 63)
+    }
+    return uint64(a)
+}
+
+
+// Likewise for floats: positive values have the sign bit set and negative
+// ones have all their bits inverted, so -Inf < -1 < -0 < 0 < 1 < +Inf.
+// NaNs go to the ends, by sign.
+func radixkeyfloat[A Float](a A) uint64 {
+    b := math.Float64bits(float64(a))
+    if b >> 63 == 1 {
+        return ^b
+    }
+    return b | 1 << 63
+}
+
+
+// LSD radix sort by a uint64 key, a byte at a time.  The counts for all
+// eight bytes are taken in one pass, and passes in which every element has
+// the same byte are skipped.  Stable.
+func radixsort[A any](l []A, key func(a A) uint64) {
+    var counts [8][256]int
+    for _, e := range l {
+        k := key(e)
+        for d := range counts {
+            counts[d][byte(k >> (8 * d))]++
+        }
+    }
+    buf := make([]A, len(l))
+    src, dst := l, buf
+    for d := range counts {
+        if slices.Contains(counts[d][:], len(l)) {
+            continue
+        }
+        offset := 0
+        for i, n := range counts[d] {
+            counts[d][i] = offset
+            offset += n
+        }
+        for _, e := range src {
+            b := byte(key(e) >> (8 * d))
+            dst[counts[d][b]] = e
+            counts[d][b]++
+        }
+        src, dst = dst, src
+    }
+    copy(l, src)
+}
+
+
+func RadixSort[A Integer](l []A) []A {
+    radixsort(l, radixkey[A])
+    return l
+}
+
+
+func RadixSortFloat[A Float](l []A) []A {
+    radixsort(l, radixkeyfloat[A])
+    return l
+}
+
+
+// The byte of s at d, plus one, or 0 if s is no longer than d, so that
+// shorter strings come first.
+func radixbyte[A ~string](s A, d int) int {
+    if d < len(s) {
+        return int(s[d]) + 1
+    }
+    return 0
+}
+
+
+// MSD radix sort: strings are distributed by their first byte, then each
+// bucket is sorted by the next byte, and so on.  Short buckets are
+// finished with insertion sort.
+func RadixSortStrings[A ~string](l []A) []A {
+    radixsortstrings(l, make([]A, len(l)), 0)
+    return l
+}
+
+
+func radixsortstrings[A ~string](l []A, buf []A, d int) {
+    if len(l) <= quicksortinsertion {
+        InsertionSort(Compare[A], l)
+        return
+    }
+    var counts [258]int
+    for _, s := range l {
+        counts[radixbyte(s, d) + 1]++
+    }
+    for i := 1; i < len(counts); i++ {
+        counts[i] += counts[i - 1]
+    }
+    offsets := counts
+    for _, s := range l {
+        b := radixbyte(s, d)
+        buf[offsets[b]] = s
+        offsets[b]++
+    }
+    copy(l, buf)
+    // Bucket 0 holds strings that have ended, which are all equal.
+    for b := 1; b < 257; b++ {
+        if counts[b + 1] - counts[b] > 1 {
+            radixsortstrings(l[counts[b]:counts[b + 1]],
+                             buf[counts[b]:counts[b + 1]],
+                             d + 1)
+        }
+    }
+}
+
+
+// CountingSort's counts may be up to this many times len(l), plus 256 so
+// that short slices of small values still count.
+const countingsortspan = 4
+
+
+// Counts the occurrences of each value between the least and the greatest,
+// then writes them back in order.  Takes memory proportional to that span,
+// so if the span is more than countingsortspan times len(l), which includes
+// spans too large for an int, sorts with RadixSort instead.
+func CountingSort[A Integer](l []A) []A {
+    if len(l) == 0 {
+        return l
+    }
+    lo, hi := slices.Min(l), slices.Max(l)
+    span := radixkey(hi) - radixkey(lo)
+    if span >= countingsortspan * uint64(len(l)) + 256 {
+        return RadixSort(l)
+    }
+    counts := make([]int, span + 1)
+    for _, e := range l {
+        counts[radixkey(e) - radixkey(lo)]++
+    }
+    i := 0
+    for j, n := range counts {
+        for ; n > 0; n-- {
+            l[i] = lo + A(j)
+            i++
+        }
+    }
+    return l
+}
+
+
+// Short slices are left to QuickSort, and counting sort is used if the
+// values span no more than twice len(l).
+func sortinteger[A Integer](l []A) {
+    if len(l) <= 256 {
+        QuickSort(Compare[A], l)
+    } else if radixkey(slices.Max(l)) - radixkey(slices.Min(l)) < 2 * uint64(len(l)) {
+        CountingSort(l)
+    } else {
+        RadixSort(l)
+    }
+}
+
+
+// Picks a sort by the element type: radix or counting sort for the
+// built-in numeric types and strings, and QuickSort for anything else,
+// including types defined on them, except floats.  Floats, and types
+// defined on them, go to RadixSortFloat whatever their number, so that,
+// unlike with QuickSort, -0 always comes before 0 and NaNs go to the ends,
+// by sign, rather than anywhere.
+func Sort[A Ordered](l []A) []A {
+    switch s := any(l).(type) {
+        case []int:
+            sortinteger(s)
+        case []int8:
+            sortinteger(s)
+        case []int16:
+            sortinteger(s)
+        case []int32:
+            sortinteger(s)
+        case []int64:
+            sortinteger(s)
+        case []uint:
+            sortinteger(s)
+        case []uint8:
+            sortinteger(s)
+        case []uint16:
+            sortinteger(s)
+        case []uint32:
+            sortinteger(s)
+        case []uint64:
+            sortinteger(s)
+        case []uintptr:
+            sortinteger(s)
+        case []float32:
+            RadixSortFloat(s)
+        case []float64:
+            RadixSortFloat(s)
+        case []string:
+            if len(s) <= 256 {
+                QuickSort(Compare[string], s)
+            } else {
+                RadixSortStrings(s)
+            }
+        default:
+            // A type defined on a float has the same representation, so
+            // its slice can be sorted as one of the float itself.
+            switch reflect.TypeFor[A]().Kind() {
+                case reflect.Float32:
+                    RadixSortFloat(unsafe.Slice((*float32)(unsafe.Pointer(unsafe.SliceData(l))),
+                                                len(l)))
+                case reflect.Float64:
+                    RadixSortFloat(unsafe.Slice((*float64)(unsafe.Pointer(unsafe.SliceData(l))),
+                                                len(l)))
+                default:
+                    QuickSort(Compare[A], l)
+            }
+    }
+    return l
+}
+
+
+func Range(m, n int) []int {
+    l := make([]int, n - m)
+    for i := 0; m < n; i++ {
+        l[i] = m
+        m++
+    }
+    return l
+}
+
+
+type SortCheck struct {
+    key int
+    index int
+}
+
+
+// Sorts a variety of inputs, with few distinct keys so that stability
+// shows, and compares the results with the standard library's.
+func check(name string,
+           stable bool,
+           sort func(compare func (x, y SortCheck) int, l []SortCheck) []SortCheck) bool {
+    compare := func(x, y SortCheck) int {
+        return Compare(x.key, y.key)
+    }
+    for _, n := range []int{ 0, 1, 2, 3, 12, 13, 63, 64, 65, 100, 1000, 10000 } {
+        reversed := Range(0, n)
+        slices.Reverse(reversed)
+        for _, input := range [][]int{ rand.Perm(n),
+                                       Range(0, n),
+                                       reversed,
+                                       make([]int, n) } {
+            l := make([]SortCheck, n)
+            for i, e := range input {
+                l[i] = SortCheck{ e % 10, i }
+            }
+            want := slices.Clone(l)
+            slices.SortStableFunc(want, compare)
+            got := sort(compare, slices.Clone(l))
+            if stable && !slices.Equal(got, want) ||
+               !stable && !slices.EqualFunc(got, want, func(x, y SortCheck) bool {
+                                                           return x.key == y.key
+                                                       }) {
+                fmt.Printf("%v failed for %v\n", name, l)
+                return false
+            }
+        }
+    }
+    return true
+}
+
+
+// Checks that NthElement, PartialSort and TopK agree with a full sort on
+// every k, comparing keys only since none of them is stable.
+func checkselect(name string,
+                 sort func(compare func (x, y SortCheck) int, l []SortCheck, k int) []SortCheck) bool {
+    compare := func(x, y SortCheck) int {
+        return Compare(x.key, y.key)
+    }
+    for _, n := range []int{ 1, 2, 3, 12, 13, 64, 100, 1000 } {
+        for _, input := range [][]int{ rand.Perm(n), Range(0, n), make([]int, n) } {
+            l := make([]SortCheck, n)
+            for i, e := range input {
+                l[i] = SortCheck{ e % 10, i }
+            }
+            want := slices.Clone(l)
+            slices.SortStableFunc(want, compare)
+            for _, k := range []int{ 0, 1, n / 3, n / 2, n - 1, n } {
+                got := sort(compare, slices.Clone(l), k)
+                if !slices.EqualFunc(got, want[:len(got)], func(x, y SortCheck) bool {
+                                                                return x.key == y.key
+                                                            }) {
+                    fmt.Printf("%v failed for %v %v\n", name, k, l)
+                    return false
+                }
+            }
+        }
+    }
+    return true
+}
+
+
+// Checks that sort puts floats in the order Sort promises, -0 before 0 and
+// NaNs at the ends, by comparing the keys RadixSortFloat sorts by.  Any
+// input includes both zeros and both NaNs.
+func checkfloats(name string, n int, sort func(l []float64) []float64) bool {
+    values := []float64{ math.NaN(), -math.NaN(), math.Copysign(0, -1), 0,
+                         math.Inf(1), math.Inf(-1), 1, -1 }
+    l := make([]float64, n)
+    for i := range l {
+        if i < len(values) {
+            l[i] = values[i]
         } else {
-            right = append(right, e)
+            l[i] = values[rand.Intn(len(values))] * float64(rand.Intn(3) + 1)
         }
     }
+    rand.Shuffle(len(l), func(i, j int) {
+                             l[i], l[j] = l[j], l[i]
+                         })
+    bits := func(l []float64) []uint64 {
+        b := make([]uint64, len(l))
+        for i, e := range l {
+            b[i] = math.Float64bits(e)
+        }
+        return slices.Sorted(slices.Values(b))
+    }
+    want := bits(l)
+    got := sort(slices.Clone(l))
+    if !slices.Equal(bits(got), want) ||
+       !slices.IsSortedFunc(got, func(x, y float64) int {
+                                     return Compare(radixkeyfloat(x), radixkeyfloat(y))
+                                 }) {
+        fmt.Printf("%v failed for %v\n", name, l)
+        return false
+    }
+    return true
+}
+
+
+// Compares sort's result with the standard library's.
+func checksort[A Ordered](name string, l []A, sort func(l []A) []A) bool {
+    want := slices.Clone(l)
+    slices.Sort(want)
+    if !slices.Equal(sort(slices.Clone(l)), want) {
+        fmt.Printf("%v failed for %v\n", name, l)
+        return false
+    }
+    return true
+}
+
 
-    l1 := make([]A, 0, len(l))
-    l1 = append(l1, QuickSort(compare, left)...)
-    l1 = append(l1, pivot)
-    l1 = append(l1, QuickSort(compare, right)...)
-    return l1
+func benchmark(name string, input func(n int) []int) {
+    const n = 100000
+    l := input(n)
+    l1 := make([]int, n)
+    sorts := []struct {
+        name string
+        sort func(l []int)
+    }{
+        { "QuickSort", func(l []int) { QuickSort(Compare[int], l) } },
+        { "HeapSort", func(l []int) { HeapSort(Compare[int], l) } },
+        { "MergeSort", func(l []int) { MergeSort(Compare[int], l) } },
+        { "TimSort", func(l []int) { TimSort(Compare[int], l) } },
+        { "RadixSort", func(l []int) { RadixSort(l) } },
+        { "CountingSort", func(l []int) { CountingSort(l) } },
+        { "Sort", func(l []int) { Sort(l) } },
+        { "SortFunc", func(l []int) { slices.SortFunc(l, Compare[int]) } },
+        { "SortStableFunc", func(l []int) { slices.SortStableFunc(l, Compare[int]) } },
+    }
+    for _, e := range sorts {
+        r := testing.Benchmark(func(b *testing.B) {
+                                   for i := 0; i < b.N; i++ {
+                                       copy(l1, l)
+                                       e.sort(l1)
+                                   }
+                               })
+        fmt.Printf("%-10v %-15v %v\n", name, e.name, r)
+    }
+}
+
+
+// Sorts the same large input with 1, 2, 4 ... GOMAXPROCS workers.
+func benchmarkparallel() {
+    const n = 1 << 21
+    l := rand.Perm(n)
+    l1 := make([]int, n)
+    sorts := []struct {
+        name string
+        sort func(l []int, workers int)
+    }{
+        { "ParallelSort", func(l []int, workers int) { ParallelSort(Compare[int], l, workers) } },
+        { "ParallelMergeSort", func(l []int, workers int) { ParallelMergeSort(Compare[int], l, workers) } },
+    }
+    for _, e := range sorts {
+        for workers := 1; workers <= runtime.GOMAXPROCS(0); workers *= 2 {
+            r := testing.Benchmark(func(b *testing.B) {
+                                       for i := 0; i < b.N; i++ {
+                                           copy(l1, l)
+                                           e.sort(l1, workers)
+                                       }
+                                   })
+            fmt.Printf("%-18v %-2v %v\n", e.name, workers, r)
+        }
+    }
 }
 
 
@@ -70,5 +1005,184 @@ func main() {
     // => []
 
 
+    l4 := []string{ "oxygen", "hydrogen", "helium", "carbon", "nitrogen" }
+    QuickSort(Compare[string], l4)
+    fmt.Printf("%v\n", l4)
+    // => [carbon helium hydrogen nitrogen oxygen]
+
+
+    l5 := []string{ "oxygen", "neon", "helium", "carbon", "argon", "boron" }
+    SortStableBy(func(s string) int { return len(s) }, l5)
+    fmt.Printf("%v\n", l5)
+    // => [neon argon boron oxygen helium carbon]
+
+
+    fmt.Printf("%v %v %v %v %v\n",
+               check("QuickSort", false, QuickSort[SortCheck]),
+               check("HeapSort", false, HeapSort[SortCheck]),
+               check("InsertionSort", true, InsertionSort[SortCheck]),
+               check("MergeSort", true, MergeSort[SortCheck]),
+               check("TimSort", true, TimSort[SortCheck]))
+    // => true true true true true
+
+
+    // The checks are all below parallelsortthreshold, so also compare a
+    // large input with the sequential sorts.
+    l7 := make([]SortCheck, 1 << 20)
+    for i, e := range rand.Perm(len(l7)) {
+        l7[i] = SortCheck{ e % 1000, i }
+    }
+    bykey := func(x, y SortCheck) int {
+        return Compare(x.key, y.key)
+    }
+    fmt.Printf("%v %v\n",
+               slices.Equal(ParallelSort(bykey, slices.Clone(l7), 4),
+                            QuickSort(bykey, slices.Clone(l7))),
+               slices.Equal(ParallelMergeSort(bykey, slices.Clone(l7), 4),
+                            MergeSort(bykey, slices.Clone(l7))))
+    // => true true
+
+    l9 := rand.Perm(1 << 20)
+    fmt.Printf("%v\n", slices.Equal(ParallelSort(Compare[int], slices.Clone(l9), 8),
+                                    QuickSort(Compare[int], l9)))
+    // => true
+
+
+    ints := make([]int, 10000)
+    for i := range ints {
+        ints[i] = rand.Intn(2000000000) - 1000000000
+    }
+    int8s := make([]int8, 1000)
+    for i := range int8s {
+        int8s[i] = int8(rand.Intn(256) - 128)
+    }
+    uint64s := make([]uint64, 1000)
+    for i := range uint64s {
+        uint64s[i] = rand.Uint64()
+    }
+    floats := []float64{ math.Inf(1), math.Inf(-1), math.Copysign(0, -1), 0 }
+    for i := 0; i < 1000; i++ {
+        floats = append(floats, rand.NormFloat64() * 1e6)
+    }
+    strings := []string{ "", "a", "ab", "abc", "b", "ba", "" }
+    for i := 0; i < 1000; i++ {
+        strings = append(strings, fmt.Sprint(rand.Intn(100000)))
+    }
+    type Celsius float64
+    celsius := []Celsius{ 100, -40, 37, 0, -273.15 }
+    fmt.Printf("%v %v %v %v %v %v %v %v %v %v %v\n",
+               checksort("RadixSort", ints, RadixSort[int]),
+               checksort("RadixSort", int8s, RadixSort[int8]),
+               checksort("RadixSort", uint64s, RadixSort[uint64]),
+               checksort("CountingSort", int8s, CountingSort[int8]),
+               checksort("RadixSortFloat", floats, RadixSortFloat[float64]),
+               checksort("RadixSortStrings", strings, RadixSortStrings[string]),
+               checksort("Sort", ints, Sort[int]),
+               checksort("Sort", Range(-1000, 1000), Sort[int]),
+               checksort("Sort", floats, Sort[float64]),
+               checksort("Sort", strings, Sort[string]),
+               checksort("Sort", celsius, Sort[Celsius]))
+    // => true true true true true true true true true true true
+
+    fmt.Printf("%v %v %v %v\n",
+               Sort([]float64{ 0, math.Copysign(0, -1), math.NaN(), 1 }),
+               checkfloats("Sort", 8, Sort[float64]),
+               checkfloats("Sort", 1000, Sort[float64]),
+               checkfloats("RadixSortFloat", 100, RadixSortFloat[float64]))
+    // => [-0 0 1 NaN] true true true
+
+    type Kelvin float32
+    fmt.Printf("%v %v\n",
+               Sort([]Celsius{ 0, Celsius(math.NaN()), Celsius(math.Copysign(0, -1)), -40 }),
+               Sort([]Kelvin{ Kelvin(math.NaN()), 0, 273.15, Kelvin(math.Inf(1)) }))
+    // => [-40 -0 0 NaN] [0 273.15 +Inf NaN]
+
+    fmt.Printf("%v %v %v\n",
+               CountingSort([]int64{ math.MaxInt64, math.MinInt64, 0 }),
+               CountingSort([]int{ 1 << 40, 0, 1 << 20 }),
+               checksort("CountingSort", ints, CountingSort[int]))
+    // => [-9223372036854775808 0 9223372036854775807] [0 1048576 1099511627776] true
+
+
+    type Freq struct {
+        s string
+        count int
+    }
+    freqs := []Freq{ { "the", 4 }, { "of", 3 }, { "a", 4 }, { "and", 3 } }
+    QuickSort(ThenBy(Reverse(By(func(f Freq) int { return f.count })),
+                     By(func(f Freq) string { return f.s })),
+              freqs)
+    fmt.Printf("%v\n", freqs)
+    // => [{a 4} {the 4} {and 3} {of 3}]
+
+    top := TopK(ThenBy(Reverse(By(func(f Freq) int { return f.count })),
+                       By(func(f Freq) string { return f.s })),
+                slices.Values([]Freq{ { "the", 4 },
+                                      { "of", 3 },
+                                      { "a", 4 },
+                                      { "and", 3 },
+                                      { "to", 2 },
+                                      { "in", 5 } }),
+                3)
+    fmt.Printf("%v\n", top)
+    // => [{in 5} {a 4} {the 4}]
+
+    l8 := []int{ 3, 1, 4, 1, 5, 9, 2, 6, 5, 4 }
+    fmt.Printf("%v %v\n",
+               Select(Compare[int], slices.Clone(l8), 4),
+               PartialSort(Compare[int], l8, 4)[:4])
+    // => 4 [1 1 2 3]
+
+    fmt.Printf("%v %v %v\n",
+               checkselect("NthElement",
+                           func(compare func (x, y SortCheck) int, l []SortCheck, k int) []SortCheck {
+                               if k == len(l) {
+                                   return nil
+                               }
+                               NthElement(compare, l, k)
+                               return QuickSort(compare, l[:k])[:k + 1]
+                           }),
+               checkselect("PartialSort",
+                           func(compare func (x, y SortCheck) int, l []SortCheck, k int) []SortCheck {
+                               return PartialSort(compare, l, k)[:k]
+                           }),
+               checkselect("TopK",
+                           func(compare func (x, y SortCheck) int, l []SortCheck, k int) []SortCheck {
+                               return TopK(compare, slices.Values(l), k)
+                           }))
+    // => true true true
+
+    one, two := 1, 2
+    l6 := []*int{ &two, nil, &one }
+    QuickSort(NullsFirst(Compare[int]), l6)
+    fmt.Printf("%v %v %v\n", l6[0], *l6[1], *l6[2])
+    // => <nil> 1 2
+
+
+    if len(os.Args) > 1 && os.Args[1] == "bench" {
+        testing.Init()
+        benchmark("random", func(n int) []int {
+                                return rand.Perm(n)
+                            })
+        benchmark("sorted", func(n int) []int {
+                                return Range(0, n)
+                            })
+        benchmark("reversed", func(n int) []int {
+                                  l := Range(0, n)
+                                  slices.Reverse(l)
+                                  return l
+                              })
+        benchmark("equal", func(n int) []int {
+                               return make([]int, n)
+                           })
+        benchmark("organpipe", func(n int) []int {
+                                   l := Range(0, n)
+                                   slices.Reverse(l[n / 2:])
+                                   return l
+                               })
+        benchmarkparallel()
+    }
+
+
     os.Exit(0)
 }
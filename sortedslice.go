@@ -0,0 +1,365 @@
+package main
+
+
+// host$ go build sortedslice.go
+// host$ ./sortedslice
+
+
+import "fmt"
+import "iter"
+import "os"
+import "slices"
+
+
+// A slice kept in order by compare, found in by binary search.  Lookups are
+// O(log n) and insertions and deletions O(n), for the copying, but with
+// none of a tree's pointers, which for all but large n makes it the faster.
+// Equal elements are allowed, and kept in the order they were inserted.
+
+
+type Ordered interface {
+    ~int | ~int8 | ~int16 | ~int32 | ~int64 |
+    ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 |
+    ~uintptr | ~float32 | ~float64 | ~string
+}
+
+
+func Compare[A Ordered](x, y A) int {
+    booltoint := func (b bool) int {
+        if b {
+            return 1
+        } else {
+            return 0
+        }
+    }
+
+    return booltoint(x > y) - booltoint(x < y)
+}
+
+
+// The index of the first element for which pred is false, or len(l) if
+// there's none, given that l is partitioned: pred is true for some prefix
+// of l and false for the rest.
+func PartitionPoint[A any](l []A, pred func(a A) bool) int {
+    i, j := 0, len(l)
+    for i < j {
+        k := i + (j - i) / 2
+        if pred(l[k]) {
+            i = k + 1
+        } else {
+            j = k
+        }
+    }
+    return i
+}
+
+
+// The index of the first element not less than v, which is where v would
+// be inserted before any equal elements.
+func LowerBound[A any](compare func (x, y A) int, l []A, v A) int {
+    return PartitionPoint(l, func(a A) bool {
+                                 return compare(a, v) < 0
+                             })
+}
+
+
+// The index of the first element greater than v, which is where v would be
+// inserted after any equal elements.
+func UpperBound[A any](compare func (x, y A) int, l []A, v A) int {
+    return PartitionPoint(l, func(a A) bool {
+                                 return compare(a, v) <= 0
+                             })
+}
+
+
+type SortedSlice[A any] struct {
+    l []A
+    compare func (x, y A) int
+}
+
+
+func SortedSliceNew[A any](compare func (x, y A) int) *SortedSlice[A] {
+    s := new(SortedSlice[A])
+    s.l = make([]A, 0)
+    s.compare = compare
+    return s
+}
+
+
+// A sorted copy of l, equal elements keeping their order.
+func SortedSliceOf[A any](compare func (x, y A) int, l ...A) *SortedSlice[A] {
+    s := SortedSliceNew(compare)
+    s.l = slices.Clone(l)
+    slices.SortStableFunc(s.l, compare)
+    return s
+}
+
+
+func (s *SortedSlice[A]) String() string {
+    return fmt.Sprint(s.l)
+}
+
+
+func (s *SortedSlice[A]) Len() int {
+    return len(s.l)
+}
+
+
+// The element at index i, the i+1th least.
+func (s *SortedSlice[A]) At(i int) A {
+    return s.l[i]
+}
+
+
+func (s *SortedSlice[A]) All() iter.Seq[A] {
+    return slices.Values(s.l)
+}
+
+
+// The index of the first element equal to a and true, or where a would be
+// inserted and false.
+func (s *SortedSlice[A]) Search(a A) (int, bool) {
+    i := LowerBound(s.compare, s.l, a)
+    return i, i < len(s.l) && s.compare(s.l[i], a) == 0
+}
+
+
+func (s *SortedSlice[A]) Contains(a A) bool {
+    _, found := s.Search(a)
+    return found
+}
+
+
+// Inserts a after any equal elements and returns its index.
+func (s *SortedSlice[A]) Insert(a A) int {
+    i := UpperBound(s.compare, s.l, a)
+    s.l = slices.Insert(s.l, i, a)
+    return i
+}
+
+
+// Deletes the first element equal to a, returning whether there was one.
+func (s *SortedSlice[A]) Delete(a A) bool {
+    i, found := s.Search(a)
+    if found {
+        s.l = slices.Delete(s.l, i, i + 1)
+    }
+    return found
+}
+
+
+// The number of elements less than a.
+func (s *SortedSlice[A]) Rank(a A) int {
+    return LowerBound(s.compare, s.l, a)
+}
+
+
+// The elements from lo up to but not including hi.
+func (s *SortedSlice[A]) Range(lo, hi A) iter.Seq[A] {
+    i := LowerBound(s.compare, s.l, lo)
+    j := i + LowerBound(s.compare, s.l[i:], hi)
+    return slices.Values(s.l[i:max(i, j)])
+}
+
+
+// The greatest element not greater than a, the last of any equal ones.
+func (s *SortedSlice[A]) Floor(a A) (A, bool) {
+    i := UpperBound(s.compare, s.l, a)
+    if i == 0 {
+        return *new(A), false
+    }
+    return s.l[i - 1], true
+}
+
+
+// The least element not less than a, the first of any equal ones.
+func (s *SortedSlice[A]) Ceiling(a A) (A, bool) {
+    i := LowerBound(s.compare, s.l, a)
+    if i == len(s.l) {
+        return *new(A), false
+    }
+    return s.l[i], true
+}
+
+
+type SortedMapEntry[K, V any] struct {
+    k K
+    v V
+}
+
+
+// An ordered alternative to HashTable: a SortedSlice of entries compared by
+// key alone, so iteration is in key order.
+type SortedMap[K, V any] struct {
+    s *SortedSlice[SortedMapEntry[K, V]]
+}
+
+
+func SortedMapNew[K, V any](compare func (x, y K) int) *SortedMap[K, V] {
+    m := new(SortedMap[K, V])
+    m.s = SortedSliceNew(func(x, y SortedMapEntry[K, V]) int {
+                             return compare(x.k, y.k)
+                         })
+    return m
+}
+
+
+func (m *SortedMap[K, V]) String() string {
+    s := "["
+    for k, v := range m.All() {
+        if len(s) > 1 {
+            s += " "
+        }
+        s += fmt.Sprintf("%v:%v", k, v)
+    }
+    return s + "]"
+}
+
+
+func (m *SortedMap[K, V]) Len() int {
+    return m.s.Len()
+}
+
+
+func (m *SortedMap[K, V]) Get(k K) (V, bool) {
+    i, found := m.s.Search(SortedMapEntry[K, V]{ k: k })
+    if !found {
+        return *new(V), false
+    }
+    return m.s.l[i].v, true
+}
+
+
+func (m *SortedMap[K, V]) Set(k K, v V) {
+    e := SortedMapEntry[K, V]{ k, v }
+    i, found := m.s.Search(e)
+    if found {
+        m.s.l[i].v = v
+    } else {
+        m.s.l = slices.Insert(m.s.l, i, e)
+    }
+}
+
+
+func (m *SortedMap[K, V]) Remove(k K) {
+    m.s.Delete(SortedMapEntry[K, V]{ k: k })
+}
+
+
+// In key order.  The map mustn't be changed during the iteration, since
+// entries move as others are inserted and deleted.
+func (m *SortedMap[K, V]) All() iter.Seq2[K, V] {
+    return func(yield func(k K, v V) bool) {
+        for _, e := range m.s.l {
+            if !yield(e.k, e.v) {
+                return
+            }
+        }
+    }
+}
+
+
+func (m *SortedMap[K, V]) Keys() iter.Seq[K] {
+    return func(yield func(k K) bool) {
+        for k, _ := range m.All() {
+            if !yield(k) {
+                return
+            }
+        }
+    }
+}
+
+
+func (m *SortedMap[K, V]) Values() iter.Seq[V] {
+    return func(yield func(v V) bool) {
+        for _, v := range m.All() {
+            if !yield(v) {
+                return
+            }
+        }
+    }
+}
+
+
+// The entries with keys from lo up to but not including hi.
+func (m *SortedMap[K, V]) Range(lo, hi K) iter.Seq2[K, V] {
+    return func(yield func(k K, v V) bool) {
+        for e := range m.s.Range(SortedMapEntry[K, V]{ k: lo },
+                                 SortedMapEntry[K, V]{ k: hi }) {
+            if !yield(e.k, e.v) {
+                return
+            }
+        }
+    }
+}
+
+
+func main() {
+    s := SortedSliceOf(Compare[int], 50, 10, 40, 20, 30)
+    s.Insert(25)
+    s.Insert(20)
+    fmt.Printf("%v %v\n", s, s.Len())
+    // => [10 20 20 25 30 40 50] 7
+
+    fmt.Printf("%v %v\n", s.Delete(20), s.Delete(35))
+    // => true false
+    fmt.Printf("%v %v %v\n", s, s.Contains(20), s.Contains(35))
+    // => [10 20 25 30 40 50] true false
+
+    fmt.Printf("%v\n", slices.Collect(s.Range(20, 40)))
+    // => [20 25 30]
+    fmt.Printf("%v %v %v\n", s.Rank(10), s.Rank(26), s.Rank(100))
+    // => 0 3 6
+
+    floor, ok1 := s.Floor(35)
+    ceiling, ok2 := s.Ceiling(35)
+    fmt.Printf("%v %v %v %v\n", floor, ok1, ceiling, ok2)
+    // => 30 true 40 true
+    floor, ok1 = s.Floor(5)
+    ceiling, ok2 = s.Ceiling(55)
+    fmt.Printf("%v %v %v %v\n", floor, ok1, ceiling, ok2)
+    // => 0 false 0 false
+
+
+    elements := []string{ "hydrogen",
+                          "helium",
+                          "lithium",
+                          "beryllium",
+                          "boron",
+                          "carbon",
+                          "nitrogen",
+                          "oxygen",
+                          "fluorine",
+                          "neon" }
+    m := SortedMapNew[string, int](Compare[string])
+    for _, e := range elements {
+        m.Set(e, len(e))
+    }
+    fmt.Println(m)
+    // => [beryllium:9 boron:5 carbon:6 fluorine:8 helium:6 ... oxygen:6]
+
+    m.Set("hydrogen", 1)
+    m.Remove("lithium")
+    v, ok := m.Get("hydrogen")
+    fmt.Printf("%v %v %v\n", v, ok, m.Len())
+    // => 1 true 9
+
+    fmt.Printf("%v\n", slices.Collect(m.Keys()))
+    // => [beryllium boron carbon fluorine helium hydrogen neon nitrogen oxygen]
+
+    for k, v := range m.Range("f", "i") {
+        fmt.Printf("%v:%v ", k, v)
+    }
+    fmt.Println()
+    // => fluorine:8 helium:6 hydrogen:1
+
+    for _, k := range slices.Collect(m.Keys()) {
+        m.Remove(k)
+    }
+    v, ok = m.Get("oxygen")
+    fmt.Printf("%v %v %v\n", v, ok, m.Len())
+    // => 0 false 0
+
+
+    os.Exit(0)
+}